@@ -2,12 +2,17 @@ package main
 
 import (
 	"fmt"
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/dns"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/driver"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/networking"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/service_lb"
 	"log"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -32,6 +37,52 @@ func main() {
 	vniStart := getEnvAsInt("VNI_START", 6514)
 	isHookAvailable := getEnvAsBool("IS_HOOK_AVAILABLE", false)
 	dnsDockerCompatibilityMode := strings.ToLower(os.Getenv("DNS_DOCKER_COMPATIBILITY_MODE")) == "true"
+	dnsTTLConfig := dns.TTLConfig{
+		Default: uint32(getEnvAsInt("DNS_TTL", 600)),
+		DNSRR:   uint32(getEnvAsInt("DNS_DNSRR_TTL", 5)),
+	}
+	dnsUpstreamConfig := dns.UpstreamConfig{
+		Servers:       lo.Compact(strings.Split(os.Getenv("DNS_UPSTREAM_SERVERS"), ",")),
+		Timeout:       time.Duration(getEnvAsInt("DNS_UPSTREAM_TIMEOUT", 2000)) * time.Millisecond,
+		RetryInterval: time.Duration(getEnvAsInt("DNS_UPSTREAM_RETRY_INTERVAL", 30)) * time.Second,
+	}
+	dnsCacheConfig := dns.CacheConfig{
+		MaxEntries:     getEnvAsInt("DNS_CACHE_SIZE", 10000),
+		MaxTTL:         time.Duration(getEnvAsInt("DNS_CACHE_MAX_TTL", 3600)) * time.Second,
+		MaxNegativeTTL: time.Duration(getEnvAsInt("DNS_CACHE_MAX_NEGATIVE_TTL", 300)) * time.Second,
+	}
+
+	rulesBackend := getEnvAsString("RULES_BACKEND", networking.RulesBackendIptables)
+	if err := networking.SetRulesBackend(rulesBackend); err != nil {
+		log.Fatalf("ERROR: %s init failed, invalid RULES_BACKEND: %v", "flannel-np", err)
+	}
+
+	dnsNameserverConfig := dns.NameserverConfig{
+		ListenIP:     getEnvAsString("DNS_LISTEN_IP", "127.0.0.33"),
+		ListenPort:   getEnvAsInt("DNS_LISTEN_PORT", 0),
+		Interception: getEnvAsString("DNS_INTERCEPTION", rulesBackend),
+	}
+	if net.ParseIP(dnsNameserverConfig.ListenIP).To4() == nil {
+		log.Fatalf("ERROR: %s init failed, DNS_LISTEN_IP %s is not a valid IPv4 address", "flannel-np", dnsNameserverConfig.ListenIP)
+	}
+	if dnsNameserverConfig.Interception != dns.InterceptionIptables && dnsNameserverConfig.Interception != dns.InterceptionNftables {
+		log.Fatalf("ERROR: %s init failed, DNS_INTERCEPTION must be %s or %s", "flannel-np", dns.InterceptionIptables, dns.InterceptionNftables)
+	}
+
+	dnsHostNameserverConfig := dns.HostNameserverConfig{
+		ListenAddress: os.Getenv("DNS_HOST_LISTEN_ADDRESS"),
+		Zone:          getEnvAsString("DNS_HOST_ZONE", "swarm.internal"),
+	}
+
+	dnsNodeNameserverConfig := dns.NodeNameserverConfig{
+		Enabled: getEnvAsBool("DNS_NODE_SERVER", false),
+		Port:    getEnvAsInt("DNS_NODE_SERVER_PORT", 53053),
+	}
+
+	serviceLbsConfig := service_lb.ServiceLbsConfig{
+		DrainTimeout: time.Duration(getEnvAsInt("LB_DRAIN_TIMEOUT", 30)) * time.Second,
+		Ingress:      getEnvAsBool("LB_INGRESS", false),
+	}
 
 	availableSubnets := []net.IPNet{}
 	for _, subnet := range availableSubnetsStrings {
@@ -45,7 +96,15 @@ func main() {
 
 	flannelDriver := driver.NewFlannelDriver(
 		etcdEndPoints, etcdPrefix, defaultFlannelOptions, availableSubnets, networkSubnetSize,
-		defaultHostSubnetSize, vniStart, dnsDockerCompatibilityMode, isHookAvailable)
+		defaultHostSubnetSize, vniStart, dnsDockerCompatibilityMode, isHookAvailable, driver.Config{
+			DNSTTL:            dnsTTLConfig,
+			DNSUpstream:       dnsUpstreamConfig,
+			DNSCache:          dnsCacheConfig,
+			DNSNameserver:     dnsNameserverConfig,
+			DNSHostNameserver: dnsHostNameserverConfig,
+			DNSNodeNameserver: dnsNodeNameserverConfig,
+			ServiceLbs:        serviceLbsConfig,
+		})
 
 	fmt.Println("Initializing Flannel plugin...")
 
@@ -72,6 +131,13 @@ func getEnvAsInt(name string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvAsString(name string, defaultVal string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultVal
+}
+
 func getEnvAsBool(name string, defaultVal bool) bool {
 	if valueStr := os.Getenv(name); valueStr != "" {
 		if strings.ToLower(valueStr) == "true" {
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/dns"
+)
+
+const (
+	getDNSRecordsPath   = "/FlannelNetworkPlugin.GetDNSRecords"
+	setDNSRecordPath    = "/FlannelNetworkPlugin.SetDNSRecord"
+	deleteDNSRecordPath = "/FlannelNetworkPlugin.DeleteDNSRecord"
+)
+
+type GetDNSRecordsResponse struct {
+	Records []dns.CustomRecord
+}
+
+type DeleteDNSRecordRequest struct {
+	Name    string
+	Network string
+}
+
+// DNSRecordsManagement manages the cluster-wide custom DNS records. It's not part of the docker plugin API,
+// but is served on the same socket, so the records can be managed from every node
+type DNSRecordsManagement interface {
+	GetDNSRecords() (*GetDNSRecordsResponse, error)
+	SetDNSRecord(*dns.CustomRecord) error
+	DeleteDNSRecord(*DeleteDNSRecordRequest) error
+	IsInitialized() bool
+}
+
+func InitDNSRecordsMux(h *sdk.Handler, m DNSRecordsManagement) {
+	o := CommonHandlerOptions{Api: "DNSRecords", IsInitialized: m.IsInitialized}
+	h.HandleFunc(getDNSRecordsPath, MakeHandlerWithOutput(o, m.GetDNSRecords))
+	h.HandleFunc(setDNSRecordPath, MakeHandlerWithInput(o, m.SetDNSRecord))
+	h.HandleFunc(deleteDNSRecordPath, MakeHandlerWithInput(o, m.DeleteDNSRecord))
+}
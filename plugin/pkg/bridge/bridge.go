@@ -2,7 +2,6 @@ package bridge
 
 import (
 	"fmt"
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
@@ -30,7 +29,7 @@ type bridgeInterface struct {
 }
 
 func NewBridgeInterface(network common.FlannelNetworkInfo) BridgeInterface {
-	interfaceName := getBridgeInterfaceName(network.FlannelID)
+	interfaceName := GetBridgeInterfaceName(network.FlannelID)
 	return &bridgeInterface{
 		interfaceName: interfaceName,
 		iptablesRules: getIptablesRules(interfaceName, network.HostSubnet),
@@ -38,7 +37,7 @@ func NewBridgeInterface(network common.FlannelNetworkInfo) BridgeInterface {
 	}
 }
 
-func getBridgeInterfaceName(flannelNetworkID string) string {
+func GetBridgeInterfaceName(flannelNetworkID string) string {
 	return networking.GetInterfaceName("fl", "-", flannelNetworkID)
 }
 
@@ -246,7 +245,7 @@ func CleanUpStaleInterfaces(validFlannelNetworkIDs []string) error {
 	}
 
 	validBridgeInterfaces := lo.Map(validFlannelNetworkIDs, func(item string, index int) string {
-		return getBridgeInterfaceName(item)
+		return GetBridgeInterfaceName(item)
 	})
 	for _, link := range links {
 		if strings.Index(link.Attrs().Name, "fl-") == 0 && !lo.Some(validBridgeInterfaces, []string{link.Attrs().Name}) {
@@ -291,26 +290,17 @@ func CleanUpStaleInterfaces(validFlannelNetworkIDs []string) error {
 func deleteIptablesRules(interfaceName string) error {
 	tableChains := map[string][]string{"nat": {"POSTROUTING", "DOCKER"}, "filter": {"FORWARD", "DOCKER-ISOLATION-STAGE-1", "DOCKER-ISOLATION-STAGE-2"}}
 
-	ipt, err := iptables.New()
-	if err != nil {
-		return fmt.Errorf("failed to initialize iptables: %v", err)
-	}
-
 	for table, chains := range tableChains {
 		for _, chain := range chains {
 			// List rules for the given table and chain
-			rules, err := ipt.List(table, chain)
+			rules, err := networking.ListRules(table, chain)
 			if err != nil {
 				return fmt.Errorf("failed to list iptables rules for table %s, chain %s: %v", table, chain, err)
 			}
 
 			for _, rule := range rules {
-				if strings.Contains(rule, interfaceName) {
-					fields := strings.Fields(rule)
-					if len(fields) > 2 && fields[0] == "-A" {
-						fields = fields[2:]
-					}
-					if err := ipt.Delete(table, chain, fields...); err != nil {
+				if strings.Contains(strings.Join(rule.RuleSpec, " "), interfaceName) {
+					if err := networking.ApplyIpTablesRules([]networking.IptablesRule{rule}, "delete"); err != nil {
 						return fmt.Errorf("failed to delete rule for table %s, chain %s: %v", table, chain, err)
 					}
 				}
@@ -21,6 +21,9 @@ type NetworkInfo struct {
 	FlannelID string `json:"FlannelID"`
 	Subnet    string `json:"Subnet"`
 	Name      string `json:"Name"`
+	DNSTTL    uint32 `json:"DNSTTL"`   // 0 if not set via the network option
+	DNSRRTTL  uint32 `json:"DNSRRTTL"` // 0 if not set via the network option
+	Ingress   bool   `json:"Ingress"`  // true for the ingress network of the routing mesh of docker
 }
 
 func (n NetworkInfo) IsFlannelNetwork() bool { return n.FlannelID != "" }
@@ -94,7 +97,7 @@ func CompareStringArrayMaps(a, b map[string][]string) bool {
 
 	for key, valA := range a {
 		valB, exists := b[key]
-		if !exists || !compareStringSlices(valA, valB) {
+		if !exists || !CompareStringSlices(valA, valB) {
 			return false
 		}
 	}
@@ -102,8 +105,8 @@ func CompareStringArrayMaps(a, b map[string][]string) bool {
 	return true
 }
 
-// Helper function to compare two string slices
-func compareStringSlices(a, b []string) bool {
+// CompareStringSlices compares two string slices, including the order of their items
+func CompareStringSlices(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
@@ -115,6 +118,19 @@ func compareStringSlices(a, b []string) bool {
 	return true
 }
 
+// CompareIPSlices compares two IP slices, including the order of their items
+func CompareIPSlices(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 type Ordered interface {
 	~int | ~int8 | ~int16 | ~int32 | ~int64 |
 		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
@@ -134,7 +150,7 @@ func (n NetworkInfo) Equals(other Equaler) bool {
 	if !ok {
 		return false
 	}
-	if n.FlannelID != o.FlannelID || n.Name != o.Name {
+	if n.FlannelID != o.FlannelID || n.Name != o.Name || n.DNSTTL != o.DNSTTL || n.DNSRRTTL != o.DNSRRTTL || n.Ingress != o.Ingress {
 		return false
 	}
 
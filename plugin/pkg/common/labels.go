@@ -0,0 +1,92 @@
+package common
+
+import (
+	"log"
+	"strconv"
+)
+
+// Labels of services and containers and options of networks that configure the behavior of the plugin
+const (
+	// DNSTTLLabel is the TTL in seconds of DNS answers for a service or - as network option - for all names in a network
+	DNSTTLLabel = "flannel-np.dns-ttl"
+	// DNSRRTTLLabel is the TTL in seconds of DNS answers for services with endpoint mode dnsrr in a network
+	DNSRRTTLLabel = "flannel-np.dnsrr-ttl"
+	// DNSPreferLocalLabel makes the DNS answers for the tasks of a service prefer the tasks on the node of the
+	// querying container. See DNSPreferLocalOrder and DNSPreferLocalFilter for the supported values
+	DNSPreferLocalLabel = "flannel-np.dns-prefer-local"
+	// DNSAllowLabel is a comma separated list of patterns of the names a container may resolve via the upstream DNS servers
+	DNSAllowLabel = "flannel-np.dns-allow"
+	// DNSDenyLabel is a comma separated list of patterns of the names a container must not resolve via the upstream DNS servers
+	DNSDenyLabel = "flannel-np.dns-deny"
+	// DNSDenyResponseLabel is the response to denied DNS queries, DNSDenyResponseRefused or DNSDenyResponseNXDomain
+	DNSDenyResponseLabel = "flannel-np.dns-deny-response"
+	// LBSchedulerLabel is the IPVS scheduler of the load balancer of a service, e.g. rr, wrr, lc, wlc, sh, mh, sed or nq
+	LBSchedulerLabel = "flannel-np.lb-scheduler"
+	// LBSchedulerFlagsLabel is a comma separated list of flags of the IPVS scheduler, e.g. sh-port or mh-fallback
+	LBSchedulerFlagsLabel = "flannel-np.lb-scheduler-flags"
+	// LBPersistenceLabel set to true sends all connections of a client to the same backend, as long as the
+	// client has connections or the persistence timeout didn't pass
+	LBPersistenceLabel = "flannel-np.lb-persistence"
+	// LBPersistenceTimeoutLabel is the persistence timeout in seconds
+	LBPersistenceTimeoutLabel = "flannel-np.lb-persistence-timeout"
+	// LBPersistenceNetmaskLabel groups the clients for persistence, e.g. 255.255.255.0 or 24
+	LBPersistenceNetmaskLabel = "flannel-np.lb-persistence-netmask"
+	// LBRestrictPortsLabel set to false load balances all TCP and UDP traffic to the VIP of a service with endpoint
+	// mode VIP, instead of only the traffic to the target ports of the service, e.g. if the service listens on
+	// ports that aren't declared in its endpoint spec
+	LBRestrictPortsLabel = "flannel-np.lb-restrict-ports"
+	// LBHealthCheckLabel enables active health checks of the backends of the load balancer of a service, tcp or http
+	LBHealthCheckLabel = "flannel-np.lb-health-check"
+	// LBHealthCheckPortLabel is the port of the health checks. Defaults to the first TCP port of the service
+	LBHealthCheckPortLabel = "flannel-np.lb-health-check-port"
+	// LBHealthCheckPathLabel is the path of HTTP health checks
+	LBHealthCheckPathLabel = "flannel-np.lb-health-check-path"
+	// LBHealthCheckIntervalLabel is the interval of the health checks in seconds
+	LBHealthCheckIntervalLabel = "flannel-np.lb-health-check-interval"
+	// LBHealthCheckTimeoutLabel is the timeout of a health check in seconds
+	LBHealthCheckTimeoutLabel = "flannel-np.lb-health-check-timeout"
+	// LBHealthCheckRetriesLabel is the number of consecutive failed health checks after which a backend is unhealthy
+	LBHealthCheckRetriesLabel = "flannel-np.lb-health-check-retries"
+	// LBWeightLabel is the IPVS weight of the backends of a container, of all containers of a service or - as
+	// label of the swarm node - of all containers on a node
+	LBWeightLabel = "flannel-np.lb-weight"
+	// LBTrafficPolicyLabel restricts the load balancer of a service on a node to the tasks on the same node. See
+	// LBTrafficPolicyLocal and LBTrafficPolicyPreferLocal for the supported values
+	LBTrafficPolicyLabel = "flannel-np.lb-traffic-policy"
+)
+
+const (
+	// DNSPreferLocalOrder lists the IPs of the tasks on the querying node first
+	DNSPreferLocalOrder = "order"
+	// DNSPreferLocalFilter only returns the IPs of the tasks on the querying node - or of all tasks if there
+	// are none on the querying node
+	DNSPreferLocalFilter = "filter"
+)
+
+const (
+	// LBTrafficPolicyLocal only load balances to the tasks on the same node. Without local tasks, the connections fail
+	LBTrafficPolicyLocal = "local"
+	// LBTrafficPolicyPreferLocal load balances to the tasks on the same node - or to all tasks if there are no
+	// local tasks that aren't draining
+	LBTrafficPolicyPreferLocal = "prefer-local"
+)
+
+const (
+	DNSDenyResponseRefused  = "refused"
+	DNSDenyResponseNXDomain = "nxdomain"
+)
+
+// ParseUint32Label returns 0 if the label doesn't exist or isn't a valid number
+func ParseUint32Label(labels map[string]string, name string) uint32 {
+	value, exists := labels[name]
+	if !exists || value == "" {
+		return 0
+	}
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.Printf("Ignoring invalid value %s of label %s: %v", value, name, err)
+		return 0
+	}
+
+	return uint32(parsed)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/samber/lo"
 	"golang.org/x/exp/maps"
 	"net"
+	"slices"
 	"sync"
 )
 
@@ -16,6 +17,18 @@ type ContainerInfo struct {
 	IPs         map[string]net.IP   `json:"IPs"`       // networkID -> IP
 	DNSNames    map[string][]string `json:"DNSNames"`  // networkID -> DNS names
 	Endpoints   map[string]string   `json:"Endpoints"` // networkID -> endpoint ID
+	Health      string              `json:"Health"`    // empty if the container has no healthcheck
+	Node        string              `json:"-"`         // hostname of the node of the container, i.e. its shard key
+	Draining    bool                `json:"Draining"`  // true after the container was killed, until it died
+	Weight      int                 `json:"Weight"`    // load balancer weight from the labels of the container or its node, 0 if not set
+}
+
+type ServicePort struct {
+	Name          string `json:"Name"`
+	Protocol      string `json:"Protocol"`   // tcp, udp or sctp
+	TargetPort    uint32 `json:"TargetPort"` // port inside the container
+	PublishedPort uint32 `json:"PublishedPort"`
+	PublishMode   string `json:"PublishMode"` // ingress or host
 }
 
 var (
@@ -23,6 +36,12 @@ var (
 	ServiceEndpointModeDnsrr = "dnsrr"
 )
 
+var (
+	ContainerHealthStarting  = "starting"
+	ContainerHealthHealthy   = "healthy"
+	ContainerHealthUnhealthy = "unhealthy"
+)
+
 // Resolver: has to be updated for every change in VIPs and backend IPs. Needs to know the endpoint mode first though
 // Load Balancer: reserves the VIPs itself and needs to communicate them outside. Needs to be updated for every change in backend IPs. May only be created for endpoint mode "vip"
 
@@ -32,21 +51,29 @@ type OnContainerData struct {
 }
 
 type ServiceEvents struct {
-	OnInitialized         EventSubscriber[Service]
-	OnVIPsChanged         EventSubscriber[Service]
-	OnNetworksChanged     EventSubscriber[Service]
-	OnEndpointModeChanged EventSubscriber[Service]
-	OnContainerAdded      EventSubscriber[OnContainerData]
-	OnContainerRemoved    EventSubscriber[OnContainerData]
+	OnInitialized            EventSubscriber[Service]
+	OnVIPsChanged            EventSubscriber[Service]
+	OnNetworksChanged        EventSubscriber[Service]
+	OnEndpointModeChanged    EventSubscriber[Service]
+	OnLabelsChanged          EventSubscriber[Service]
+	OnPortsChanged           EventSubscriber[Service]
+	OnContainerAdded         EventSubscriber[OnContainerData]
+	OnContainerRemoved       EventSubscriber[OnContainerData]
+	OnContainerDraining      EventSubscriber[OnContainerData]
+	OnContainerWeightChanged EventSubscriber[OnContainerData]
 }
 
 type serviceEvents struct {
-	onInitialized         Event[Service]
-	onVIPsChanged         Event[Service]
-	onNetworksChanged     Event[Service]
-	onEndpointModeChanged Event[Service]
-	onContainerAdded      Event[OnContainerData]
-	onContainerRemoved    Event[OnContainerData]
+	onInitialized            Event[Service]
+	onVIPsChanged            Event[Service]
+	onNetworksChanged        Event[Service]
+	onEndpointModeChanged    Event[Service]
+	onLabelsChanged          Event[Service]
+	onPortsChanged           Event[Service]
+	onContainerAdded         Event[OnContainerData]
+	onContainerRemoved       Event[OnContainerData]
+	onContainerDraining      Event[OnContainerData]
+	onContainerWeightChanged Event[OnContainerData]
 }
 
 // Service
@@ -59,7 +86,13 @@ type Service interface {
 	SetNetworks(networks []string, ipamVIPs map[string]net.IP)
 	SetEndpointMode(endpoint string)
 	SetVIPs(map[string]net.IP)
+	SetPorts(ports []ServicePort)
+	SetLabels(labels map[string]string)
 	AddContainer(container ContainerInfo)
+	// UpdateContainer replaces the data of a known container, e.g. after its health changed, without raising
+	// OnContainerAdded. Unknown containers are added. Raises OnContainerDraining when the container started draining
+	// and OnContainerWeightChanged when its weight changed
+	UpdateContainer(container ContainerInfo)
 	RemoveContainer(containerID string)
 	Events() ServiceEvents
 }
@@ -71,6 +104,8 @@ type ServiceInfo struct {
 	Networks     []string
 	VIPs         map[string]net.IP
 	IpamVIPs     map[string]net.IP
+	Ports        []ServicePort
+	Labels       map[string]string
 	Containers   map[string]ContainerInfo
 }
 
@@ -81,6 +116,8 @@ type service struct {
 	networks     []string
 	vips         map[string]net.IP
 	ipamVIPs     map[string]net.IP
+	ports        []ServicePort
+	labels       map[string]string
 	containers   map[string]ContainerInfo
 	events       serviceEvents
 	sync.Mutex
@@ -88,12 +125,16 @@ type service struct {
 
 func NewService(id, name string) Service {
 	events := serviceEvents{
-		onInitialized:         NewEvent[Service](),
-		onVIPsChanged:         NewEvent[Service](),
-		onNetworksChanged:     NewEvent[Service](),
-		onEndpointModeChanged: NewEvent[Service](),
-		onContainerAdded:      NewEvent[OnContainerData](),
-		onContainerRemoved:    NewEvent[OnContainerData](),
+		onInitialized:            NewEvent[Service](),
+		onVIPsChanged:            NewEvent[Service](),
+		onNetworksChanged:        NewEvent[Service](),
+		onEndpointModeChanged:    NewEvent[Service](),
+		onLabelsChanged:          NewEvent[Service](),
+		onPortsChanged:           NewEvent[Service](),
+		onContainerAdded:         NewEvent[OnContainerData](),
+		onContainerRemoved:       NewEvent[OnContainerData](),
+		onContainerDraining:      NewEvent[OnContainerData](),
+		onContainerWeightChanged: NewEvent[OnContainerData](),
 	}
 	return &service{
 		id:         id,
@@ -101,6 +142,8 @@ func NewService(id, name string) Service {
 		networks:   make([]string, 0),
 		vips:       map[string]net.IP{},
 		ipamVIPs:   map[string]net.IP{},
+		ports:      make([]ServicePort, 0),
+		labels:     map[string]string{},
 		containers: map[string]ContainerInfo{},
 		events:     events,
 	}
@@ -108,12 +151,16 @@ func NewService(id, name string) Service {
 
 func (s *service) Events() ServiceEvents {
 	return ServiceEvents{
-		OnInitialized:         s.events.onInitialized,
-		OnVIPsChanged:         s.events.onVIPsChanged,
-		OnNetworksChanged:     s.events.onNetworksChanged,
-		OnEndpointModeChanged: s.events.onEndpointModeChanged,
-		OnContainerAdded:      s.events.onContainerAdded,
-		OnContainerRemoved:    s.events.onContainerRemoved,
+		OnInitialized:            s.events.onInitialized,
+		OnVIPsChanged:            s.events.onVIPsChanged,
+		OnNetworksChanged:        s.events.onNetworksChanged,
+		OnEndpointModeChanged:    s.events.onEndpointModeChanged,
+		OnLabelsChanged:          s.events.onLabelsChanged,
+		OnPortsChanged:           s.events.onPortsChanged,
+		OnContainerAdded:         s.events.onContainerAdded,
+		OnContainerRemoved:       s.events.onContainerRemoved,
+		OnContainerDraining:      s.events.onContainerDraining,
+		OnContainerWeightChanged: s.events.onContainerWeightChanged,
 	}
 }
 
@@ -131,13 +178,15 @@ func (s *service) GetInfo() ServiceInfo {
 		Networks:     s.networks,
 		VIPs:         s.vips,
 		IpamVIPs:     s.ipamVIPs,
+		Ports:        s.ports,
+		Labels:       s.labels,
 		Containers:   s.containers,
 	}
 }
 
 func (s *service) SetVIPs(vips map[string]net.IP) {
 	s.Lock()
-	vipsChanged := CompareIPMaps(s.vips, vips)
+	vipsChanged := !CompareIPMaps(s.vips, vips)
 	s.vips = maps.Clone(vips)
 	s.Unlock()
 
@@ -146,6 +195,29 @@ func (s *service) SetVIPs(vips map[string]net.IP) {
 	}
 }
 
+func (s *service) SetPorts(ports []ServicePort) {
+	s.Lock()
+	portsChanged := !slices.Equal(s.ports, ports)
+	s.ports = make([]ServicePort, len(ports))
+	copy(s.ports, ports)
+	s.Unlock()
+
+	if s.IsInitialized() && portsChanged {
+		s.events.onPortsChanged.Raise(s)
+	}
+}
+
+func (s *service) SetLabels(labels map[string]string) {
+	s.Lock()
+	labelsChanged := !CompareStringMaps(s.labels, labels)
+	s.labels = maps.Clone(labels)
+	s.Unlock()
+
+	if s.IsInitialized() && labelsChanged {
+		s.events.onLabelsChanged.Raise(s)
+	}
+}
+
 func (s *service) AddContainer(container ContainerInfo) {
 	s.Lock()
 	s.containers[container.ID] = container
@@ -159,6 +231,36 @@ func (s *service) AddContainer(container ContainerInfo) {
 	}
 }
 
+func (s *service) UpdateContainer(container ContainerInfo) {
+	s.Lock()
+	previous, exists := s.containers[container.ID]
+	if exists {
+		s.containers[container.ID] = container
+	}
+	s.Unlock()
+
+	if !exists {
+		s.AddContainer(container)
+		return
+	}
+	if !s.IsInitialized() {
+		return
+	}
+
+	if container.Draining && !previous.Draining {
+		s.events.onContainerDraining.Raise(OnContainerData{
+			Service:   s,
+			Container: container,
+		})
+	}
+	if container.Weight != previous.Weight {
+		s.events.onContainerWeightChanged.Raise(OnContainerData{
+			Service:   s,
+			Container: container,
+		})
+	}
+}
+
 func (s *service) RemoveContainer(containerID string) {
 	s.Lock()
 
@@ -183,7 +285,7 @@ func (s *service) SetNetworks(networks []string, ipamVIPs map[string]net.IP) {
 	wasInitialized := s.IsInitialized()
 
 	deletedNetworks, addedNetworks := lo.Difference(networks, s.networks)
-	ipamVIPsChanged := CompareIPMaps(s.ipamVIPs, ipamVIPs)
+	ipamVIPsChanged := !CompareIPMaps(s.ipamVIPs, ipamVIPs)
 
 	s.networks = make([]string, len(networks))
 	copy(s.networks, networks)
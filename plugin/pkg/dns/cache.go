@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/miekg/dns"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	// MaxEntries is the maximum number of cached responses. 0 disables the cache
+	MaxEntries int
+	// MaxTTL caps the TTL of positive responses
+	MaxTTL time.Duration
+	// MaxNegativeTTL caps the TTL of negative responses (NXDOMAIN and NODATA)
+	MaxNegativeTTL time.Duration
+}
+
+type cacheEntry struct {
+	key       string
+	response  *dns.Msg
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+// cachingForwarder caches the responses of the upstream servers, respecting the TTLs of the records.
+// Negative responses are cached according to RFC 2308, i.e. using the SOA record in the authority section.
+// The least recently used responses are evicted when the cache is full
+type cachingForwarder struct {
+	forwarder Forwarder
+	config    CacheConfig
+	entries   map[string]*list.Element
+	lru       *list.List // front is the most recently used
+	sync.Mutex
+}
+
+// NewCachingForwarder returns a forwarder that caches the responses of forwarder.
+// If the cache is disabled, forwarder is returned as is
+func NewCachingForwarder(forwarder Forwarder, config CacheConfig) Forwarder {
+	if config.MaxEntries <= 0 {
+		fmt.Println("DNS cache is disabled")
+		return forwarder
+	}
+
+	return &cachingForwarder{
+		forwarder: forwarder,
+		config:    config,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+func (c *cachingForwarder) Forward(request *dns.Msg, options ForwardOptions) (*dns.Msg, error) {
+	if len(request.Question) != 1 {
+		return c.forwarder.Forward(request, options)
+	}
+
+	key := cacheKey(request, options)
+	if response := c.get(key, request); response != nil {
+		return response, nil
+	}
+
+	response, err := c.forwarder.Forward(request, options)
+	if err != nil {
+		return nil, err
+	}
+
+	c.set(key, response)
+
+	return response, nil
+}
+
+func (c *cachingForwarder) get(key string, request *dns.Msg) *dns.Msg {
+	c.Lock()
+	defer c.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+
+	entry := element.Value.(*cacheEntry)
+	now := time.Now()
+	if now.After(entry.expiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+
+	response := entry.response.Copy()
+	response.Id = request.Id
+	elapsed := uint32(now.Sub(entry.storedAt).Seconds())
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, record := range section {
+			if record.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if record.Header().Ttl > elapsed {
+				record.Header().Ttl -= elapsed
+			} else {
+				record.Header().Ttl = 0
+			}
+		}
+	}
+
+	return response
+}
+
+func (c *cachingForwarder) set(key string, response *dns.Msg) {
+	ttl, cacheable := c.getTTL(response)
+	if !cacheable || ttl == 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	entry := &cacheEntry{
+		key:       key,
+		response:  response.Copy(),
+		storedAt:  now,
+		expiresAt: now.Add(ttl),
+	}
+
+	if element, exists := c.entries[key]; exists {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// getTTL returns the minimum TTL of all records for positive responses and the TTL of the SOA record
+// for negative responses. Responses that indicate an error or are truncated are not cacheable
+func (c *cachingForwarder) getTTL(response *dns.Msg) (ttl time.Duration, cacheable bool) {
+	if response.Truncated {
+		return 0, false
+	}
+
+	isNegative := response.Rcode == dns.RcodeNameError ||
+		(response.Rcode == dns.RcodeSuccess && len(response.Answer) == 0)
+
+	if isNegative {
+		for _, record := range response.Ns {
+			if soa, ok := record.(*dns.SOA); ok {
+				seconds := min(soa.Hdr.Ttl, soa.Minttl)
+				return min(time.Duration(seconds)*time.Second, c.config.MaxNegativeTTL), true
+			}
+		}
+		// Without an SOA record, negative responses must not be cached
+		return 0, false
+	}
+
+	if response.Rcode != dns.RcodeSuccess {
+		return 0, false
+	}
+
+	minTTL := c.config.MaxTTL
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, record := range section {
+			if record.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			minTTL = min(minTTL, time.Duration(record.Header().Ttl)*time.Second)
+		}
+	}
+
+	return minTTL, true
+}
+
+// cacheKey includes the upstream servers, because containers with different upstream servers
+// may get different answers for the same question
+func cacheKey(request *dns.Msg, options ForwardOptions) string {
+	question := request.Question[0]
+	dnssecOK := false
+	if optRR := request.IsEdns0(); optRR != nil {
+		dnssecOK = optRR.Do()
+	}
+
+	return fmt.Sprintf("%s|%d|%d|%t|%t|%s", strings.ToLower(question.Name), question.Qtype, question.Qclass,
+		dnssecOK, request.CheckingDisabled, strings.Join(options.Servers, ","))
+}
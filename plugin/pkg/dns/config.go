@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"encoding/json"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DaemonConfigPath = "/hostfs/etc/docker/daemon.json"
+
+// DNSConfig is the DNS configuration of a container, as specified via --dns, --dns-search, --dns-option
+// and --domainname or the corresponding settings of a service or the docker daemon
+type DNSConfig struct {
+	Servers    []string
+	Search     []string
+	Options    []string
+	Domainname string
+	// Policy is specified via labels of the container or its service
+	Policy QueryPolicy
+}
+
+type dnsOptions struct {
+	ndots    int
+	timeout  time.Duration
+	attempts int
+	rotate   bool
+}
+
+// WithDefaults returns the config with the search domains and options of defaults if the config has none.
+// The servers are not taken from defaults, because the Forwarder already falls back to the default servers
+func (c DNSConfig) WithDefaults(defaults DNSConfig) DNSConfig {
+	result := c
+	if len(result.Search) == 0 {
+		result.Search = defaults.Search
+	}
+	if len(result.Options) == 0 {
+		result.Options = defaults.Options
+	}
+
+	return result
+}
+
+// searchDomains returns the search domains and the domainname as fully qualified, lower case domain names
+func (c DNSConfig) searchDomains() []string {
+	result := []string{}
+	for _, domain := range append(c.Search, c.Domainname) {
+		domain = strings.Trim(strings.ToLower(domain), ".")
+		if domain == "" {
+			continue
+		}
+		result = append(result, dns.Fqdn(domain))
+	}
+
+	return result
+}
+
+// parseOptions supports the resolv.conf options ndots, timeout, attempts and rotate. All other options
+// are ignored
+func (c DNSConfig) parseOptions() dnsOptions {
+	result := dnsOptions{}
+	for _, option := range c.Options {
+		name, value, _ := strings.Cut(option, ":")
+		switch name {
+		case "ndots":
+			if ndots, err := strconv.Atoi(value); err == nil && ndots >= 0 {
+				result.ndots = ndots
+			} else {
+				log.Printf("Ignoring invalid DNS option %s", option)
+			}
+		case "timeout":
+			if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+				result.timeout = time.Duration(timeout) * time.Second
+			} else {
+				log.Printf("Ignoring invalid DNS option %s", option)
+			}
+		case "attempts":
+			if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+				result.attempts = attempts
+			} else {
+				log.Printf("Ignoring invalid DNS option %s", option)
+			}
+		case "rotate":
+			result.rotate = true
+		}
+	}
+
+	return result
+}
+
+// ReadDaemonDNSConfig reads the settings dns, dns-search and dns-opts from the config of the docker daemon
+func ReadDaemonDNSConfig() DNSConfig {
+	config, err := readDaemonDNSConfig(DaemonConfigPath)
+	if err != nil {
+		log.Printf("Error reading DNS config from docker daemon config %s: %v", DaemonConfigPath, err)
+	}
+
+	return config
+}
+
+func readDaemonDNSConfig(path string) (DNSConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DNSConfig{}, nil
+		}
+		return DNSConfig{}, err
+	}
+
+	daemonConfig := struct {
+		DNS        []string `json:"dns"`
+		DNSSearch  []string `json:"dns-search"`
+		DNSOptions []string `json:"dns-opts"`
+	}{}
+	if err := json.Unmarshal(content, &daemonConfig); err != nil {
+		return DNSConfig{}, errors.WithMessagef(err, "error parsing %s", path)
+	}
+
+	return DNSConfig{
+		Servers: daemonConfig.DNS,
+		Search:  daemonConfig.DNSSearch,
+		Options: daemonConfig.DNSOptions,
+	}, nil
+}
+
+// searchCandidates returns the name itself and, for each search domain the name ends with,
+// the name without that search domain
+func searchCandidates(name string, searchDomains []string) []string {
+	result := []string{name}
+	fqdn := dns.Fqdn(name)
+	lowerName := strings.ToLower(fqdn)
+	for _, domain := range searchDomains {
+		suffix := "." + domain
+		if strings.HasSuffix(lowerName, suffix) && len(lowerName) > len(suffix) {
+			result = append(result, dns.Fqdn(fqdn[:len(fqdn)-len(suffix)]))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/etcd"
+	"net"
+	"strings"
+)
+
+const (
+	CustomRecordTypeA     = "A"
+	CustomRecordTypeCNAME = "CNAME"
+)
+
+// CustomRecord is an additional A or CNAME record, e.g. for hosts outside the swarm. It is stored in etcd
+// and resolves inside all containers on our networks - or only on a specific network if Network is set
+type CustomRecord struct {
+	Name    string   `json:"Name"`
+	Type    string   `json:"Type"`    // A or CNAME
+	IPs     []net.IP `json:"IPs"`     // only for type A
+	Target  string   `json:"Target"`  // only for type CNAME
+	Network string   `json:"Network"` // network name or ID. Empty means all networks
+	TTL     uint32   `json:"TTL"`     // 0 means the plugin level TTL
+}
+
+func (r CustomRecord) Equals(other common.Equaler) bool {
+	o, ok := other.(CustomRecord)
+	if !ok {
+		return false
+	}
+
+	return r.Name == o.Name && r.Type == o.Type && r.Target == o.Target && r.Network == o.Network &&
+		r.TTL == o.TTL && common.CompareIPSlices(r.IPs, o.IPs)
+}
+
+// ID is the key of the record in etcd. There is at most one record per name and network
+func (r CustomRecord) ID() string {
+	name := normalizeName(r.Name)
+	if r.Network == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s@%s", name, r.Network)
+}
+
+func (r CustomRecord) Validate() error {
+	name := normalizeName(r.Name)
+	if name == "" {
+		return fmt.Errorf("name of DNS record is empty")
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return fmt.Errorf("name %s of DNS record is not a valid domain name", r.Name)
+	}
+	if strings.Contains(r.Network, "/") {
+		return fmt.Errorf("network %s of DNS record %s is invalid", r.Network, r.Name)
+	}
+
+	switch r.Type {
+	case CustomRecordTypeA:
+		if len(r.IPs) == 0 {
+			return fmt.Errorf("A record %s has no IPs", r.Name)
+		}
+		for _, ip := range r.IPs {
+			if ip.To4() == nil {
+				return fmt.Errorf("A record %s has invalid IPv4 address %s", r.Name, ip)
+			}
+		}
+	case CustomRecordTypeCNAME:
+		target := normalizeName(r.Target)
+		if target == "" {
+			return fmt.Errorf("CNAME record %s has no target", r.Name)
+		}
+		if _, ok := dns.IsDomainName(target); !ok {
+			return fmt.Errorf("target %s of CNAME record %s is not a valid domain name", r.Target, r.Name)
+		}
+		if target == name {
+			return fmt.Errorf("CNAME record %s points to itself", r.Name)
+		}
+	default:
+		return fmt.Errorf("type %s of DNS record %s is not supported. Supported types are %s and %s", r.Type, r.Name, CustomRecordTypeA, CustomRecordTypeCNAME)
+	}
+
+	return nil
+}
+
+// CustomRecords manages the custom DNS records of the cluster. The records can be changed from every node
+// - or directly in etcd - and are watched by all nodes
+type CustomRecords interface {
+	Init() error
+	GetAll() []CustomRecord
+	SetRecord(record CustomRecord) error
+	DeleteRecord(name, network string) error
+}
+
+type customRecords struct {
+	etcdClient etcd.Client
+	store      etcd.ReadOnlyStore[CustomRecord]
+}
+
+// NewCustomRecords onChanged is invoked with all records whenever a record has been added, changed or removed
+func NewCustomRecords(etcdClient etcd.Client, onChanged func(records []CustomRecord)) CustomRecords {
+	result := &customRecords{
+		etcdClient: etcdClient,
+	}
+
+	notify := func() { onChanged(result.GetAll()) }
+	result.store = etcd.NewReadOnlyStore(etcdClient, etcd.ItemsHandlers[CustomRecord]{
+		OnAdded:   func([]etcd.Item[CustomRecord]) { notify() },
+		OnChanged: func([]etcd.ItemChange[CustomRecord]) { notify() },
+		OnRemoved: func([]etcd.Item[CustomRecord]) { notify() },
+	})
+
+	return result
+}
+
+func (c *customRecords) Init() error {
+	if err := c.store.Init(); err != nil {
+		return errors.WithMessage(err, "error initializing custom DNS records")
+	}
+
+	fmt.Printf("Loaded %d custom DNS records\n", len(c.store.GetAll()))
+
+	return nil
+}
+
+func (c *customRecords) GetAll() []CustomRecord {
+	result := []CustomRecord{}
+	for _, record := range c.store.GetAll() {
+		result = append(result, record)
+	}
+
+	return result
+}
+
+func (c *customRecords) SetRecord(record CustomRecord) error {
+	if err := record.Validate(); err != nil {
+		return err
+	}
+
+	record.Name = normalizeName(record.Name)
+	if record.Type == CustomRecordTypeCNAME {
+		record.Target = normalizeName(record.Target)
+	}
+
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to serialize DNS record %s", record.Name)
+	}
+
+	_, err = etcd.WithConnection(c.etcdClient, func(connection *etcd.Connection) (bool, error) {
+		return connection.PutIfNewOrChanged(c.etcdClient.GetKey(record.ID()), string(bytes))
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to store DNS record %s", record.Name)
+	}
+
+	return nil
+}
+
+func (c *customRecords) DeleteRecord(name, network string) error {
+	id := CustomRecord{Name: name, Network: network}.ID()
+	_, err := etcd.WithConnection(c.etcdClient, func(connection *etcd.Connection) (struct{}, error) {
+		resp, err := connection.Client.Delete(connection.Ctx, c.etcdClient.GetKey(id))
+		if err != nil {
+			return struct{}{}, err
+		}
+		if resp.Deleted == 0 {
+			return struct{}{}, fmt.Errorf("DNS record %s does not exist", id)
+		}
+		return struct{}{}, nil
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to delete DNS record %s", id)
+	}
+
+	return nil
+}
+
+// normalizeName returns the name in lower case and without trailing dot - the format the resolver uses internally
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
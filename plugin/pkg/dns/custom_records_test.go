@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCustomRecordValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  CustomRecord
+		isValid bool
+	}{
+		{"A record", CustomRecord{Name: "db.example.com", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}}, true},
+		{"A record with network", CustomRecord{Name: "db", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}, Network: "web"}, true},
+		{"A record with trailing dot and upper case", CustomRecord{Name: "DB.Example.com.", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}}, true},
+		{"A record without IPs", CustomRecord{Name: "db", Type: CustomRecordTypeA}, false},
+		{"A record with IPv6 address", CustomRecord{Name: "db", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("fd00::1")}}, false},
+		{"empty name", CustomRecord{Name: "", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}}, false},
+		{"invalid name", CustomRecord{Name: "db..example", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}}, false},
+		{"invalid network", CustomRecord{Name: "db", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}, Network: "a/b"}, false},
+		{"CNAME record", CustomRecord{Name: "www", Type: CustomRecordTypeCNAME, Target: "web.example.com"}, true},
+		{"CNAME record without target", CustomRecord{Name: "www", Type: CustomRecordTypeCNAME}, false},
+		{"CNAME record with invalid target", CustomRecord{Name: "www", Type: CustomRecordTypeCNAME, Target: "web..example"}, false},
+		{"CNAME record pointing to itself", CustomRecord{Name: "www", Type: CustomRecordTypeCNAME, Target: "WWW."}, false},
+		{"unsupported type", CustomRecord{Name: "mail", Type: "MX", Target: "mx.example.com"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.record.Validate()
+			if test.isValid && err != nil {
+				t.Errorf("expected record to be valid, got %v", err)
+			}
+			if !test.isValid && err == nil {
+				t.Errorf("expected record to be invalid")
+			}
+		})
+	}
+}
+
+func TestCustomRecordID(t *testing.T) {
+	tests := []struct {
+		record   CustomRecord
+		expected string
+	}{
+		{CustomRecord{Name: "DB.example.com."}, "db.example.com"},
+		{CustomRecord{Name: "db", Network: "web"}, "db@web"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if actual := test.record.ID(); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
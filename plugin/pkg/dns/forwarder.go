@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	HostResolvConfPath    = "/hostfs/etc/resolv.conf"
+	SystemdResolvConfPath = "/hostfs/var/run/systemd/resolve/resolv.conf"
+)
+
+type UpstreamConfig struct {
+	// Servers are only used if neither the docker daemon nor the host specify any DNS servers
+	Servers []string
+	// Timeout per query and upstream server
+	Timeout time.Duration
+	// RetryInterval is the time during which an upstream server that failed to respond is skipped
+	RetryInterval time.Duration
+}
+
+// ForwardOptions are the per container settings for forwarding. Zero values mean that the defaults are used
+type ForwardOptions struct {
+	// Servers are the upstream servers of the container. If empty, the default upstream servers of
+	// the node are used instead.
+	Servers  []string
+	Timeout  time.Duration
+	Attempts int
+	// Rotate spreads the queries over all healthy servers instead of always starting with the first one
+	Rotate bool
+}
+
+// Forwarder forwards DNS queries that can't be answered internally to upstream DNS servers
+// It is shared by all nameservers of a node, so that the health state of the upstream servers is, too.
+type Forwarder interface {
+	// Forward sends the request to the first healthy upstream server
+	Forward(request *dns.Msg, options ForwardOptions) (*dns.Msg, error)
+}
+
+type forwarder struct {
+	defaultServers []string
+	timeout        time.Duration
+	retryInterval  time.Duration
+	unhealthyUntil *common.ConcurrentMap[string, time.Time] // server address -> time
+}
+
+func NewForwarder(config UpstreamConfig) Forwarder {
+	defaultServers := getDefaultUpstreamServers(config.Servers)
+	fmt.Printf("Using default upstream DNS servers %v\n", defaultServers)
+
+	return &forwarder{
+		defaultServers: defaultServers,
+		timeout:        config.Timeout,
+		retryInterval:  config.RetryInterval,
+		unhealthyUntil: common.NewConcurrentMap[string, time.Time](),
+	}
+}
+
+func (f *forwarder) Forward(request *dns.Msg, options ForwardOptions) (*dns.Msg, error) {
+	// The queries are forwarded from the network namespace of the plugin, so loopback servers of the
+	// container would be the loopback servers of the host
+	servers := normalizeUpstreamServers(options.Servers)
+	if len(servers) == 0 {
+		servers = f.defaultServers
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no upstream DNS servers configured")
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = f.timeout
+	}
+	attempts := common.Max(options.Attempts, 1)
+
+	servers = f.orderByHealth(servers, options.Rotate)
+	attemptedServers := []string{}
+	for i := 0; i < attempts; i++ {
+		attemptedServers = append(attemptedServers, servers...)
+	}
+
+	var lastErr error
+	var lastResponse *dns.Msg
+	for _, server := range attemptedServers {
+		response, err := f.exchange(request, server, timeout)
+		if err != nil {
+			log.Printf("Upstream DNS server %s failed, skipping it for %s: %v", server, f.retryInterval, err)
+			f.unhealthyUntil.Set(server, time.Now().Add(f.retryInterval))
+			lastErr = err
+			continue
+		}
+		f.unhealthyUntil.Remove(server)
+
+		if response.Rcode == dns.RcodeServerFailure || response.Rcode == dns.RcodeRefused {
+			// The server is alive, but couldn't answer this query. Maybe another one can
+			lastResponse = response
+			continue
+		}
+
+		return response, nil
+	}
+
+	if lastResponse != nil {
+		return lastResponse, nil
+	}
+
+	return nil, errors.WithMessagef(lastErr, "all upstream DNS servers %v failed", servers)
+}
+
+func (f *forwarder) exchange(request *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: timeout}
+	response, _, err := c.Exchange(request, server)
+	if err == nil && !response.Truncated {
+		return response, nil
+	}
+
+	c.Net = "tcp"
+	response, _, err = c.Exchange(request, server)
+	return response, err
+}
+
+// orderByHealth keeps the order of the servers, but moves the servers that recently failed to the end,
+// so they are only used as a last resort. With rotate, the order of the healthy servers is randomized
+func (f *forwarder) orderByHealth(servers []string, rotate bool) []string {
+	now := time.Now()
+	healthy, unhealthy := lo.FilterReject(servers, func(server string, _ int) bool {
+		until, exists := f.unhealthyUntil.Get(server)
+		return !exists || now.After(until)
+	})
+	if rotate {
+		healthy = lo.Shuffle(healthy)
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// getDefaultUpstreamServers returns the DNS servers from the docker daemon config or - if it has none -
+// the DNS servers of the host. fallback is used if neither has any usable DNS servers
+func getDefaultUpstreamServers(fallback []string) []string {
+	servers := normalizeUpstreamServers(ReadDaemonDNSConfig().Servers)
+	if len(servers) > 0 {
+		return servers
+	}
+
+	// On hosts with systemd-resolved, /etc/resolv.conf only contains the local stub resolver, which
+	// can't be reached from inside the containers
+	for _, path := range []string{HostResolvConfPath, SystemdResolvConfPath} {
+		config, err := dns.ClientConfigFromFile(path)
+		if err != nil {
+			log.Printf("Error reading DNS servers from %s: %v", path, err)
+			continue
+		}
+		servers = normalizeUpstreamServers(lo.Map(config.Servers, func(item string, _ int) string {
+			return net.JoinHostPort(item, config.Port)
+		}))
+		if len(servers) > 0 {
+			return servers
+		}
+	}
+
+	return normalizeUpstreamServers(fallback)
+}
+
+// normalizeUpstreamServers adds the default port to servers without one and removes servers
+// that would result in a loop or are unreachable from inside the containers, i.e. loopback addresses
+func normalizeUpstreamServers(servers []string) []string {
+	result := []string{}
+	for _, server := range servers {
+		if server == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+			port = "53"
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			log.Printf("Ignoring invalid upstream DNS server %s", server)
+			continue
+		}
+		if ip.IsLoopback() {
+			continue
+		}
+		result = append(result, net.JoinHostPort(ip.String(), port))
+	}
+
+	return lo.Uniq(result)
+}
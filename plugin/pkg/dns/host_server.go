@@ -0,0 +1,234 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// hostZoneNegativeTTL is low, because names in the zone appear as soon as services are created
+const hostZoneNegativeTTL = 5
+
+type HostNameserverConfig struct {
+	// ListenAddress is the address in the host network namespace, e.g. 10.0.0.5:53. Empty disables the
+	// host nameserver
+	ListenAddress string
+	// Zone is the zone the host nameserver is authoritative for, e.g. swarm.internal
+	Zone string
+}
+
+// HostNameserver makes the names of services and containers resolvable outside of the containers, e.g.
+// for processes on the swarm hosts or machines outside the swarm.
+// It is authoritative for names of the form <service or container>.<network>.<zone>,
+// tasks.<service>.<network>.<zone> and _<port>._<protocol>.<service>.<network>.<zone>, including the custom
+// records of the network, and for the PTR records of the IPs of the networks.
+// Other names are refused, the host nameserver doesn't forward
+type HostNameserver interface {
+	Start() error
+	Stop() error
+}
+
+type hostNameserver struct {
+	listenAddress string
+	zone          string
+	resolver      Resolver
+	udpServer     *dns.Server
+	tcpServer     *dns.Server
+}
+
+func NewHostNameserver(config HostNameserverConfig, resolver Resolver) HostNameserver {
+	listenAddress := config.ListenAddress
+	if _, _, err := net.SplitHostPort(listenAddress); err != nil {
+		listenAddress = net.JoinHostPort(listenAddress, "53")
+	}
+
+	return &hostNameserver{
+		listenAddress: listenAddress,
+		zone:          dns.Fqdn(strings.ToLower(strings.Trim(config.Zone, "."))),
+		resolver:      resolver,
+	}
+}
+
+func (h *hostNameserver) Start() error {
+	udpConn, err := net.ListenPacket("udp", h.listenAddress)
+	if err != nil {
+		return errors.WithMessagef(err, "Failed to create UDP listener on %s", h.listenAddress)
+	}
+	tcpListener, err := net.Listen("tcp", h.listenAddress)
+	if err != nil {
+		udpConn.Close()
+		return errors.WithMessagef(err, "Failed to create TCP listener on %s", h.listenAddress)
+	}
+
+	h.udpServer = &dns.Server{Handler: h, PacketConn: udpConn}
+	h.tcpServer = &dns.Server{Handler: h, Listener: tcpListener}
+	for _, server := range []*dns.Server{h.udpServer, h.tcpServer} {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				log.Printf("Host DNS server on %s stopped: %v", h.listenAddress, err)
+			}
+		}(server)
+	}
+
+	fmt.Printf("Host DNS server for zone %s listening on %s\n", h.zone, h.listenAddress)
+	return nil
+}
+
+func (h *hostNameserver) Stop() error {
+	for _, server := range []*dns.Server{h.udpServer, h.tcpServer} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(); err != nil {
+			return errors.WithMessagef(err, "Error shutting down host DNS server on %s", h.listenAddress)
+		}
+	}
+
+	return nil
+}
+
+func (h *hostNameserver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	msg := dns.Msg{}
+	msg.SetReply(r)
+
+	if len(r.Question) != 1 {
+		msg.SetRcode(r, dns.RcodeFormatError)
+	} else if q := r.Question[0]; q.Qtype == dns.TypePTR {
+		msg.Answer = h.resolvePTR(q)
+		if len(msg.Answer) == 0 {
+			if h.resolver.IsInternalPTR(q.Name) {
+				// Unknown IPs of our networks don't exist
+				msg.SetRcode(r, dns.RcodeNameError)
+			} else {
+				// We aren't authoritative for other reverse zones, so let the client ask its other servers
+				msg.SetRcode(r, dns.RcodeRefused)
+			}
+		}
+	} else if dns.IsSubDomain(h.zone, q.Name) {
+		msg.Authoritative = true
+		h.resolveInZone(q, &msg)
+	} else {
+		msg.SetRcode(r, dns.RcodeRefused)
+	}
+
+	if err := writeResponse(w, r, &msg); err != nil {
+		log.Printf("Failed to write response of host DNS server: %v", err)
+	}
+}
+
+func (h *hostNameserver) resolveInZone(q dns.Question, msg *dns.Msg) {
+	relativeName := strings.TrimSuffix(q.Name[:len(q.Name)-len(h.zone)], ".")
+	if relativeName == "" {
+		if q.Qtype == dns.TypeSOA {
+			msg.Answer = []dns.RR{newSOA(h.zone, hostZoneNegativeTTL)}
+		} else {
+			msg.Ns = []dns.RR{newSOA(h.zone, hostZoneNegativeTTL)}
+		}
+		return
+	}
+
+	networkID, name, exists := h.getNetworkID(relativeName)
+	if !exists {
+		msg.Rcode = dns.RcodeNameError
+		msg.Ns = []dns.RR{newSOA(h.zone, hostZoneNegativeTTL)}
+		return
+	}
+
+	query := dns.Fqdn(relativeName)
+	validNetworkIDs := []string{networkID}
+	switch q.Qtype {
+	case dns.TypeA:
+		msg.Answer = h.resolveName(query, name, validNetworkIDs)
+	case dns.TypeSRV:
+		msg.Answer, msg.Extra = h.resolver.ResolveSRV(query, validNetworkIDs)
+	}
+
+	for _, answer := range msg.Answer {
+		if strings.EqualFold(answer.Header().Name, query) {
+			answer.Header().Name = q.Name
+		}
+		if srv, ok := answer.(*dns.SRV); ok {
+			srv.Target = srv.Target + h.zone
+		}
+	}
+	for _, extra := range msg.Extra {
+		extra.Header().Name = extra.Header().Name + h.zone
+	}
+
+	if len(msg.Answer) == 0 {
+		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeSRV || len(h.resolveName(query, name, validNetworkIDs)) == 0 {
+			msg.Rcode = dns.RcodeNameError
+		}
+		msg.Ns = []dns.RR{newSOA(h.zone, hostZoneNegativeTTL)}
+	}
+}
+
+// resolveName resolves the name with its network, e.g. app.web, and falls back to the name without the network,
+// e.g. app, because the custom records of the network are registered without it. The answers are returned for query
+func (h *hostNameserver) resolveName(query string, name string, validNetworkIDs []string) []dns.RR {
+	answers := h.resolver.ResolveName(query, validNetworkIDs)
+	if len(answers) > 0 {
+		return answers
+	}
+
+	nameQuery := dns.Fqdn(name)
+	answers = h.resolver.ResolveName(nameQuery, validNetworkIDs)
+	for _, answer := range answers {
+		if strings.EqualFold(answer.Header().Name, nameQuery) {
+			answer.Header().Name = query
+		}
+	}
+
+	return answers
+}
+
+// resolvePTR returns names in our zone for IPs of containers and service VIPs of all networks
+func (h *hostNameserver) resolvePTR(q dns.Question) []dns.RR {
+	answers := h.resolver.ResolveIP(q.Name, lo.Values(h.resolver.GetNetworkIDs()))
+	for _, answer := range answers {
+		if ptr, ok := answer.(*dns.PTR); ok {
+			ptr.Ptr = ptr.Ptr + h.zone
+		}
+	}
+
+	return answers
+}
+
+// getNetworkID returns the ID of the network the name ends with and the name without the network. Network names
+// may contain dots, so longer network names are preferred
+func (h *hostNameserver) getNetworkID(relativeName string) (string, string, bool) {
+	networkIDs := h.resolver.GetNetworkIDs()
+	labels := dns.SplitDomainName(relativeName)
+	// The first label is always the name of the service or container
+	for i := 1; i < len(labels); i++ {
+		if networkID, exists := networkIDs[normalizeName(strings.Join(labels[i:], "."))]; exists {
+			return networkID, strings.Join(labels[:i], "."), true
+		}
+	}
+
+	return "", "", false
+}
+
+// newSOA returns the SOA record for negative answers of zone, see RFC 2308
+func newSOA(zone string, negativeTTL uint32) dns.RR {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    negativeTTL,
+		},
+		Ns:      "ns." + zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  negativeTTL,
+	}
+}
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"testing"
+)
+
+type testResponseWriter struct {
+	network  string
+	response *dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	if w.network == "tcp" {
+		return &net.TCPAddr{IP: net.ParseIP("127.0.0.11"), Port: 53}
+	}
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.11"), Port: 53}
+}
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("10.1.0.5"), Port: 40000}
+}
+func (w *testResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.response = msg
+	return nil
+}
+func (w *testResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testResponseWriter) Close() error                { return nil }
+func (w *testResponseWriter) TsigStatus() error           { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool)         {}
+func (w *testResponseWriter) Hijack()                     {}
+
+func TestHostNameserverServeDNS(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		qtype           uint16
+		expectedRcode   int
+		expectedAnswers int
+	}{
+		{"A record of a service", "app.web.swarm.internal.", dns.TypeA, dns.RcodeSuccess, 1},
+		{"A record of a service in another network", "app.internal.swarm.internal.", dns.TypeA, dns.RcodeNameError, 0},
+		{"custom record of the network", "db.web.swarm.internal.", dns.TypeA, dns.RcodeSuccess, 1},
+		{"custom record of another network", "db.internal.swarm.internal.", dns.TypeA, dns.RcodeNameError, 0},
+		{"unknown network", "app.unknown.swarm.internal.", dns.TypeA, dns.RcodeNameError, 0},
+		{"name outside of the zone", "example.com.", dns.TypeA, dns.RcodeRefused, 0},
+		{"PTR of a container", "5.0.1.10.in-addr.arpa.", dns.TypePTR, dns.RcodeSuccess, 1},
+		{"PTR of an unknown internal IP", "9.0.1.10.in-addr.arpa.", dns.TypePTR, dns.RcodeNameError, 0},
+		{"PTR of an external IP", "1.2.0.192.in-addr.arpa.", dns.TypePTR, dns.RcodeRefused, 0},
+	}
+
+	r, _ := newTestResolver()
+	r.SetCustomRecords([]CustomRecord{{Name: "db", Type: CustomRecordTypeA, IPs: []net.IP{net.ParseIP("10.0.0.1")}, Network: "web"}})
+	h := NewHostNameserver(HostNameserverConfig{ListenAddress: "127.0.0.1", Zone: "swarm.internal"}, r).(*hostNameserver)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := &dns.Msg{}
+			request.SetQuestion(test.query, test.qtype)
+			w := &testResponseWriter{network: "udp"}
+			h.ServeDNS(w, request)
+			response := w.response
+
+			if response.Rcode != test.expectedRcode {
+				t.Errorf("expected rcode %s, got %s", dns.RcodeToString[test.expectedRcode], dns.RcodeToString[response.Rcode])
+			}
+			if len(response.Answer) != test.expectedAnswers {
+				t.Errorf("expected %d answers, got %v", test.expectedAnswers, response.Answer)
+			}
+			for _, answer := range response.Answer {
+				if answer.Header().Name != test.query {
+					t.Errorf("expected answers for %s, got %s", test.query, answer.Header().Name)
+				}
+			}
+		})
+	}
+}
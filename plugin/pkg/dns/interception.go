@@ -0,0 +1,297 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/networking"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Mechanisms that redirect the DNS traffic of a container from 127.0.0.11:53 to our DNS servers
+const (
+	InterceptionIptables = "iptables"
+	InterceptionNftables = "nftables"
+)
+
+const (
+	nftablesTable = "flannel_dns"
+	// nftablesOutputPriority is before the nat chains of iptables (-100), so our DNAT wins over the one of docker,
+	// without having to wait for and delete the rules of docker
+	nftablesOutputPriority      = -110
+	nftablesPostroutingPriority = 90
+)
+
+// dnsRedirect describes where the DNS traffic to 127.0.0.11:53 inside the namespace of a container is redirected to
+type dnsRedirect struct {
+	ip      net.IP
+	portTCP int
+	portUDP int
+	// masquerade is needed if the target isn't inside the namespace, so the queries have a source address
+	// that is routable and identifies the container
+	masquerade bool
+}
+
+// intercept redirects the DNS traffic in the current namespace with the configured mechanism
+func (n *nameserver) intercept(ctx context.Context, redirect dnsRedirect) error {
+	switch n.config.Interception {
+	case InterceptionNftables:
+		return n.applyNftablesRules(ctx, redirect)
+	default:
+		return n.replaceDNATSNATRules(ctx, redirect.iptablesRules())
+	}
+}
+
+func (r dnsRedirect) iptablesRules() []networking.IptablesRule {
+	rules := []networking.IptablesRule{}
+	for _, protocol := range []string{"tcp", "udp"} {
+		port := r.port(protocol)
+		rules = append(rules, networking.IptablesRule{
+			Chain: "FLANNEL_DNS_OUTPUT",
+			RuleSpec: []string{
+				"-d", "127.0.0.11/32",
+				"-p", protocol,
+				"-m", protocol,
+				"--dport", "53",
+				"-j", "DNAT",
+				"--to-destination", fmt.Sprintf("%s:%d", r.ip, port),
+			},
+		})
+		if r.masquerade {
+			rules = append(rules, networking.IptablesRule{
+				Chain: "FLANNEL_DNS_POSTROUTING",
+				RuleSpec: []string{
+					"-d", fmt.Sprintf("%s/32", r.ip),
+					"-p", protocol,
+					"-m", protocol,
+					"--dport", strconv.Itoa(port),
+					"-j", "MASQUERADE",
+				},
+			})
+		} else {
+			rules = append(rules, networking.IptablesRule{
+				Chain: "FLANNEL_DNS_POSTROUTING",
+				RuleSpec: []string{
+					"-s", fmt.Sprintf("%s/32", r.ip),
+					"-p", protocol,
+					"-m", protocol,
+					"--sport", strconv.Itoa(port),
+					"-j", "SNAT",
+					"--to-source", ":53",
+				},
+			})
+		}
+	}
+
+	postroutingMatch := []string{"-d", "127.0.0.11"}
+	if r.masquerade {
+		// After the DNAT, the destination is the target, so we match the original destination
+		postroutingMatch = []string{"-m", "conntrack", "--ctorigdst", "127.0.0.11"}
+	}
+
+	return append(rules,
+		networking.IptablesRule{
+			Chain: "OUTPUT",
+			RuleSpec: []string{
+				"-d", "127.0.0.11",
+				"-j", "FLANNEL_DNS_OUTPUT",
+			},
+		},
+		networking.IptablesRule{
+			Chain:    "POSTROUTING",
+			RuleSpec: append(postroutingMatch, "-j", "FLANNEL_DNS_POSTROUTING"),
+		})
+}
+
+// nftablesRuleset returns a ruleset that replaces our table atomically. Conntrack restores 127.0.0.11:53 as
+// the source of the replies
+func (r dnsRedirect) nftablesRuleset() string {
+	var b strings.Builder
+	// Adding the table before deleting it makes the deletion work, even if the table doesn't exist yet
+	fmt.Fprintf(&b, "add table ip %s\n", nftablesTable)
+	fmt.Fprintf(&b, "delete table ip %s\n", nftablesTable)
+	fmt.Fprintf(&b, "table ip %s {\n", nftablesTable)
+	fmt.Fprintf(&b, "\tchain output {\n\t\ttype nat hook output priority %d; policy accept;\n", nftablesOutputPriority)
+	for _, protocol := range []string{"tcp", "udp"} {
+		fmt.Fprintf(&b, "\t\tip daddr 127.0.0.11 %s dport 53 dnat to %s:%d\n", protocol, r.ip, r.port(protocol))
+	}
+	fmt.Fprintf(&b, "\t}\n")
+	if r.masquerade {
+		fmt.Fprintf(&b, "\tchain postrouting {\n\t\ttype nat hook postrouting priority %d; policy accept;\n", nftablesPostroutingPriority)
+		fmt.Fprintf(&b, "\t\tct original ip daddr 127.0.0.11 masquerade\n")
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	return b.String()
+}
+
+func (r dnsRedirect) port(protocol string) int {
+	if protocol == "tcp" {
+		return r.portTCP
+	}
+
+	return r.portUDP
+}
+
+// applyNftablesRules replaces our nftables table in the current namespace. It fails if another nat chain
+// would see the DNS traffic before ours, because its DNAT would take precedence, or if iptables rules other than
+// the ones of docker redirect DNS traffic, because our chain would silently override them
+func (n *nameserver) applyNftablesRules(ctx context.Context, redirect dnsRedirect) error {
+	chains, err := listNftablesNatOutputChains(ctx)
+	if err != nil {
+		return errors.WithMessagef(err, "Error listing nftables chains in namespace %s", n.networkNamespace)
+	}
+	for _, chain := range chains {
+		if chain.table != nftablesTable && chain.priority <= nftablesOutputPriority {
+			return fmt.Errorf("chain %s of nftables table %s in namespace %s has priority %d and would intercept the DNS traffic before our chain with priority %d", chain.name, chain.table, n.networkNamespace, chain.priority, nftablesOutputPriority)
+		}
+	}
+
+	// The rules of iptables-legacy aren't part of the nftables ruleset
+	if output, err := exec.CommandContext(ctx, "iptables", "-t", "nat", "-S").Output(); err == nil {
+		if rules := getConflictingIptablesDNSRules(string(output)); len(rules) > 0 {
+			return fmt.Errorf("iptables rules in namespace %s redirect the DNS traffic and would be overridden by our chain: %s", n.networkNamespace, strings.Join(rules, "; "))
+		}
+	} else {
+		// Images without the nat table of iptables can't have rules in it
+		fmt.Printf("Not checking the iptables rules in namespace %s: %v\n", n.networkNamespace, err)
+	}
+
+	ruleset := redirect.nftablesRuleset()
+	fmt.Printf("Applying nftables rules in namespace %s:\n%s", n.networkNamespace, ruleset)
+	cmd := exec.CommandContext(ctx, "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.WithMessagef(err, "Error applying nftables rules in namespace %s: %s", n.networkNamespace, output)
+	}
+
+	return nil
+}
+
+// getConflictingIptablesDNSRules returns the DNAT rules for port 53 that are reachable from the nat OUTPUT chain in
+// the output of iptables -t nat -S. The DNAT of docker to its embedded DNS server on 127.0.0.11 and our own rules
+// of the iptables interception are expected and not returned
+func getConflictingIptablesDNSRules(output string) []string {
+	rulesByChain := map[string][][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		rulesByChain[fields[1]] = append(rulesByChain[fields[1]], fields)
+	}
+
+	result := []string{}
+	visited := map[string]bool{}
+	var visit func(chain string)
+	visit = func(chain string) {
+		if visited[chain] || strings.HasPrefix(chain, "FLANNEL_DNS_") {
+			return
+		}
+		visited[chain] = true
+		for _, fields := range rulesByChain[chain] {
+			target := getIptablesOption(fields, "-j")
+			if _, isChain := rulesByChain[target]; isChain {
+				visit(target)
+				continue
+			}
+			if target != "DNAT" || getIptablesOption(fields, "--dport") != "53" {
+				continue
+			}
+			if strings.HasPrefix(getIptablesOption(fields, "--to-destination"), "127.0.0.11:") {
+				continue
+			}
+			result = append(result, strings.Join(fields, " "))
+		}
+	}
+	visit("OUTPUT")
+
+	return result
+}
+
+func getIptablesOption(fields []string, option string) string {
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == option {
+			return fields[i+1]
+		}
+	}
+
+	return ""
+}
+
+type nftablesChain struct {
+	table    string
+	name     string
+	priority int
+}
+
+// listNftablesNatOutputChains returns the chains of the current namespace that are nat chains of the
+// output hook for IPv4
+func listNftablesNatOutputChains(ctx context.Context) ([]nftablesChain, error) {
+	output, err := exec.CommandContext(ctx, "nft", "list", "chains").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []nftablesChain{}
+	var table, chain string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.ReplaceAll(scanner.Text(), ";", " ;"))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "table":
+			table = ""
+			// table <family> <name> {
+			if len(fields) >= 3 && (fields[1] == "ip" || fields[1] == "inet") {
+				table = fields[2]
+			}
+		case "chain":
+			if len(fields) >= 2 {
+				chain = fields[1]
+			}
+		case "type":
+			// type nat hook output priority -100; policy accept;
+			if table == "" || len(fields) < 6 || fields[1] != "nat" || fields[3] != "output" || fields[4] != "priority" {
+				continue
+			}
+			priority, err := parseNftablesPriority(fields[5:])
+			if err != nil {
+				return nil, errors.WithMessagef(err, "invalid priority of chain %s in table %s", chain, table)
+			}
+			result = append(result, nftablesChain{table: table, name: chain, priority: priority})
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// parseNftablesPriority parses priorities like -100, dstnat or dstnat - 10
+func parseNftablesPriority(fields []string) (int, error) {
+	namedPriorities := map[string]int{"raw": -300, "mangle": -150, "dstnat": -100, "filter": 0, "security": 50, "srcnat": 100}
+
+	priority, isNamed := namedPriorities[fields[0]]
+	if !isNamed {
+		return strconv.Atoi(fields[0])
+	}
+	if len(fields) >= 3 && (fields[1] == "+" || fields[1] == "-") {
+		offset, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, err
+		}
+		if fields[1] == "-" {
+			offset = -offset
+		}
+		priority += offset
+	}
+
+	return priority, nil
+}
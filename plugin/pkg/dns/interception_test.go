@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetConflictingIptablesDNSRules(t *testing.T) {
+	dockerRules := `-P PREROUTING ACCEPT
+-P OUTPUT ACCEPT
+-N DOCKER_OUTPUT
+-N DOCKER_POSTROUTING
+-A OUTPUT -d 127.0.0.11/32 -j DOCKER_OUTPUT
+-A DOCKER_OUTPUT -d 127.0.0.11/32 -p tcp -m tcp --dport 53 -j DNAT --to-destination 127.0.0.11:36591
+-A DOCKER_OUTPUT -d 127.0.0.11/32 -p udp -m udp --dport 53 -j DNAT --to-destination 127.0.0.11:51738
+-A DOCKER_POSTROUTING -s 127.0.0.11/32 -p udp -m udp --sport 51738 -j SNAT --to-source :53
+`
+	tests := []struct {
+		name     string
+		output   string
+		expected []string
+	}{
+		{"no rules", "-P OUTPUT ACCEPT\n", []string{}},
+		{"docker", dockerRules, []string{}},
+		{
+			"our iptables interception",
+			dockerRules + "-N FLANNEL_DNS_OUTPUT\n-A OUTPUT -d 127.0.0.11/32 -j FLANNEL_DNS_OUTPUT\n-A FLANNEL_DNS_OUTPUT -d 127.0.0.11/32 -p udp -m udp --dport 53 -j DNAT --to-destination 127.0.0.33:53\n",
+			[]string{},
+		},
+		{
+			"other DNAT in OUTPUT",
+			dockerRules + "-A OUTPUT -p udp -m udp --dport 53 -j DNAT --to-destination 10.0.0.2:53\n",
+			[]string{"-A OUTPUT -p udp -m udp --dport 53 -j DNAT --to-destination 10.0.0.2:53"},
+		},
+		{
+			"other DNAT in a chain reachable from OUTPUT",
+			"-N SIDECAR\n-A OUTPUT -j SIDECAR\n-A SIDECAR -p tcp --dport 53 -j DNAT --to-destination 127.0.0.1:5353\n",
+			[]string{"-A SIDECAR -p tcp --dport 53 -j DNAT --to-destination 127.0.0.1:5353"},
+		},
+		{
+			"DNAT in PREROUTING",
+			"-A PREROUTING -p udp --dport 53 -j DNAT --to-destination 10.0.0.2:53\n",
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := getConflictingIptablesDNSRules(test.output)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
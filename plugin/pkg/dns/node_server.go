@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"golang.org/x/sys/unix"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// refusalLogInterval limits the logs about refused queries, so that a client that keeps sending queries can't
+// flood the log
+const refusalLogInterval = 10 * time.Second
+
+type NodeNameserverConfig struct {
+	// Enabled selects the node-wide DNS server instead of a DNS server per container
+	Enabled bool
+	// Port in the host network namespace on which the node-wide DNS server listens for UDP and TCP on the local
+	// gateway of each flannel network
+	Port int
+}
+
+// LocalGateway is the bridge of a flannel network on this node
+type LocalGateway struct {
+	IP            net.IP
+	Subnet        *net.IPNet
+	InterfaceName string
+}
+
+// NodeNameserver is a single DNS server per node, as an alternative to running a DNS server in the network
+// namespace of every container. The DNS traffic of the containers is redirected to the local gateway of one
+// of their flannel networks and the container is identified by the bridge the query arrived on and the source
+// address of the query.
+// In this mode, the Nameserver of a container only registers the container with the NodeNameserver
+type NodeNameserver interface {
+	Stop() error
+	// AddNetwork starts listening on the local gateway of the network. The listeners are bound to the bridge of the
+	// network, so the server isn't reachable via any other interface of the node
+	AddNetwork(dockerNetworkID string) error
+	RemoveNetwork(dockerNetworkID string) error
+	// GetListenAddress returns the IP - the local gateway of one of the networks - and port to which the DNS traffic
+	// of a container with the given networks should be redirected
+	GetListenAddress(validNetworkIDs []string) (ip net.IP, port int, exists bool)
+	// Register makes handler answer the queries with one of the source IPs
+	Register(sourceIPs []net.IP, handler dns.Handler)
+	// UnregisterSourceIPs removes the source IPs of handler, e.g. after its container left a network
+	UnregisterSourceIPs(sourceIPs []net.IP, handler dns.Handler)
+	Unregister(handler dns.Handler)
+}
+
+type nodeNameserver struct {
+	port             int
+	getLocalGateway  func(dockerNetworkID string) (LocalGateway, bool)
+	handlersBySource *common.ConcurrentMap[string, dns.Handler]   // source IP -> handler
+	listeners        *common.ConcurrentMap[string, *nodeListener] // docker network ID -> listener
+	refusals         refusalLog
+	sync.Mutex
+}
+
+type nodeListener struct {
+	gateway   LocalGateway
+	udpServer *dns.Server
+	tcpServer *dns.Server
+}
+
+type refusalLog struct {
+	lastLog time.Time
+	dropped int
+	sync.Mutex
+}
+
+// NewNodeNameserver getLocalGateway returns the bridge of the network on this node
+func NewNodeNameserver(config NodeNameserverConfig, getLocalGateway func(dockerNetworkID string) (LocalGateway, bool)) NodeNameserver {
+	return &nodeNameserver{
+		port:             config.Port,
+		getLocalGateway:  getLocalGateway,
+		handlersBySource: common.NewConcurrentMap[string, dns.Handler](),
+		listeners:        common.NewConcurrentMap[string, *nodeListener](),
+	}
+}
+
+func (s *nodeNameserver) Stop() error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, dockerNetworkID := range s.listeners.Keys() {
+		if err := s.removeNetworkLocked(dockerNetworkID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *nodeNameserver) AddNetwork(dockerNetworkID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	gateway, exists := s.getLocalGateway(dockerNetworkID)
+	if !exists {
+		return fmt.Errorf("network %s has no local gateway", dockerNetworkID)
+	}
+
+	if listener, exists := s.listeners.Get(dockerNetworkID); exists {
+		if listener.gateway.IP.Equal(gateway.IP) && listener.gateway.InterfaceName == gateway.InterfaceName {
+			return nil
+		}
+		if err := s.removeNetworkLocked(dockerNetworkID); err != nil {
+			return err
+		}
+	}
+
+	listener, err := s.listen(gateway)
+	if err != nil {
+		return errors.WithMessagef(err, "Error starting node DNS server for network %s", dockerNetworkID)
+	}
+	s.listeners.Set(dockerNetworkID, listener)
+
+	return nil
+}
+
+func (s *nodeNameserver) RemoveNetwork(dockerNetworkID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeNetworkLocked(dockerNetworkID)
+}
+
+func (s *nodeNameserver) removeNetworkLocked(dockerNetworkID string) error {
+	listener, exists := s.listeners.Get(dockerNetworkID)
+	if !exists {
+		return nil
+	}
+	s.listeners.Remove(dockerNetworkID)
+
+	for _, server := range []*dns.Server{listener.udpServer, listener.tcpServer} {
+		if err := server.Shutdown(); err != nil {
+			return errors.WithMessagef(err, "Error shutting down node DNS server on %s", listener.gateway.IP)
+		}
+	}
+
+	return nil
+}
+
+// listen binds to the IP and the interface of the gateway. Without binding to the interface, the kernel would
+// accept queries for the gateway IP on any interface of the node
+func (s *nodeNameserver) listen(gateway LocalGateway) (*nodeListener, error) {
+	listenConfig := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var bindErr error
+			if err := c.Control(func(fd uintptr) {
+				bindErr = unix.BindToDevice(int(fd), gateway.InterfaceName)
+			}); err != nil {
+				return err
+			}
+			return bindErr
+		},
+	}
+
+	listenAddress := net.JoinHostPort(gateway.IP.String(), strconv.Itoa(s.port))
+	udpConn, err := listenConfig.ListenPacket(context.Background(), "udp", listenAddress)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Failed to create UDP listener on %s (%s)", listenAddress, gateway.InterfaceName)
+	}
+	tcpListener, err := listenConfig.Listen(context.Background(), "tcp", listenAddress)
+	if err != nil {
+		udpConn.Close()
+		return nil, errors.WithMessagef(err, "Failed to create TCP listener on %s (%s)", listenAddress, gateway.InterfaceName)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		s.serveDNS(gateway, w, r)
+	})
+	listener := &nodeListener{
+		gateway:   gateway,
+		udpServer: &dns.Server{Handler: handler, PacketConn: udpConn},
+		tcpServer: &dns.Server{Handler: handler, Listener: tcpListener},
+	}
+	for _, server := range []*dns.Server{listener.udpServer, listener.tcpServer} {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				log.Printf("Node DNS server on %s stopped: %v", listenAddress, err)
+			}
+		}(server)
+	}
+
+	fmt.Printf("Node DNS server listening on %s (%s)\n", listenAddress, gateway.InterfaceName)
+	return listener, nil
+}
+
+func (s *nodeNameserver) GetListenAddress(validNetworkIDs []string) (net.IP, int, bool) {
+	// Sorted, so that a container keeps the same address, independent of the order its networks were joined
+	sortedNetworkIDs := make([]string, len(validNetworkIDs))
+	copy(sortedNetworkIDs, validNetworkIDs)
+	sort.Strings(sortedNetworkIDs)
+
+	for _, networkID := range sortedNetworkIDs {
+		if listener, exists := s.listeners.Get(networkID); exists {
+			return listener.gateway.IP, s.port, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+func (s *nodeNameserver) Register(sourceIPs []net.IP, handler dns.Handler) {
+	for _, ip := range sourceIPs {
+		s.handlersBySource.Set(ip.String(), handler)
+	}
+}
+
+func (s *nodeNameserver) UnregisterSourceIPs(sourceIPs []net.IP, handler dns.Handler) {
+	for _, ip := range sourceIPs {
+		if registered, exists := s.handlersBySource.Get(ip.String()); exists && registered == handler {
+			s.handlersBySource.Remove(ip.String())
+		}
+	}
+}
+
+func (s *nodeNameserver) Unregister(handler dns.Handler) {
+	for _, ip := range s.handlersBySource.Keys() {
+		if registered, exists := s.handlersBySource.Get(ip); exists && registered == handler {
+			s.handlersBySource.Remove(ip)
+		}
+	}
+}
+
+// serveDNS hands the query to the container with the source IP. The source IP has to be in the subnet of the
+// bridge the query arrived on, so a container can only use the addresses of its own networks
+func (s *nodeNameserver) serveDNS(gateway LocalGateway, w dns.ResponseWriter, r *dns.Msg) {
+	var sourceIP net.IP
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		sourceIP = addr.IP
+	case *net.TCPAddr:
+		sourceIP = addr.IP
+	}
+
+	handler, exists := s.handlersBySource.Get(sourceIP.String())
+	if !exists || gateway.Subnet == nil || !gateway.Subnet.Contains(sourceIP) {
+		s.refusals.log(w.RemoteAddr(), gateway.InterfaceName)
+		msg := &dns.Msg{}
+		msg.SetRcode(r, dns.RcodeRefused)
+		if err := writeResponse(w, r, msg); err != nil {
+			log.Printf("Failed to write DNS response to %s: %v", w.RemoteAddr(), err)
+		}
+		return
+	}
+
+	handler.ServeDNS(w, r)
+}
+
+func (l *refusalLog) log(remoteAddr net.Addr, interfaceName string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if time.Since(l.lastLog) < refusalLogInterval {
+		l.dropped++
+		return
+	}
+
+	if l.dropped > 0 {
+		log.Printf("Refusing DNS query from unknown source %s on %s. Refused %d more queries in the last %s", remoteAddr, interfaceName, l.dropped, time.Since(l.lastLog).Round(time.Second))
+	} else {
+		log.Printf("Refusing DNS query from unknown source %s on %s", remoteAddr, interfaceName)
+	}
+	l.lastLog = time.Now()
+	l.dropped = 0
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"log"
+	"strings"
+)
+
+// QueryPolicy restricts which names a container may resolve via the upstream servers. Names of containers,
+// services and custom records are always resolved.
+// A pattern example.com matches example.com and all its subdomains, *.example.com only the subdomains
+// and * matches all names
+type QueryPolicy struct {
+	// Allow lists the patterns of the names that may be forwarded. Empty means all names
+	Allow []string
+	// Deny lists the patterns of the names that must not be forwarded. It takes precedence over Allow
+	Deny []string
+	// DenyRcode is the response code of denied queries, dns.RcodeRefused or dns.RcodeNameError.
+	// Use GetDenyRcode, because the zero value is NOERROR
+	DenyRcode int
+}
+
+// NewQueryPolicy creates the policy from the labels flannel-np.dns-allow, flannel-np.dns-deny and
+// flannel-np.dns-deny-response of a service or container
+func NewQueryPolicy(labels map[string]string) QueryPolicy {
+	result := QueryPolicy{
+		Allow:     parsePatterns(labels[common.DNSAllowLabel]),
+		Deny:      parsePatterns(labels[common.DNSDenyLabel]),
+		DenyRcode: dns.RcodeRefused,
+	}
+
+	switch response := strings.ToLower(labels[common.DNSDenyResponseLabel]); response {
+	case "", common.DNSDenyResponseRefused:
+	case common.DNSDenyResponseNXDomain:
+		result.DenyRcode = dns.RcodeNameError
+	default:
+		log.Printf("Ignoring invalid value %s of label %s", response, common.DNSDenyResponseLabel)
+	}
+
+	return result
+}
+
+func (p QueryPolicy) IsAllowed(name string) bool {
+	name = normalizeName(name)
+	if len(p.Allow) > 0 && !matchesAnyPattern(name, p.Allow) {
+		return false
+	}
+
+	return !matchesAnyPattern(name, p.Deny)
+}
+
+// GetDenyRcode returns the response code of denied queries, dns.RcodeRefused if none is set
+func (p QueryPolicy) GetDenyRcode() int {
+	if p.DenyRcode == dns.RcodeSuccess {
+		return dns.RcodeRefused
+	}
+
+	return p.DenyRcode
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if domain, isWildcard := strings.CutPrefix(pattern, "*."); isWildcard {
+			if strings.HasSuffix(name, "."+domain) {
+				return true
+			}
+		} else if name == pattern || strings.HasSuffix(name, "."+pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parsePatterns parses a comma separated list of patterns
+func parsePatterns(value string) []string {
+	result := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = normalizeName(pattern); pattern != "" {
+			result = append(result, pattern)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"testing"
+)
+
+func TestQueryPolicyIsAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		query    string
+		expected bool
+	}{
+		{"no labels", nil, "example.com.", true},
+		{"allowed domain", map[string]string{common.DNSAllowLabel: "example.com"}, "example.com.", true},
+		{"subdomain of allowed domain", map[string]string{common.DNSAllowLabel: "example.com"}, "api.example.com.", true},
+		{"other domain", map[string]string{common.DNSAllowLabel: "example.com"}, "example.org.", false},
+		{"suffix without dot", map[string]string{common.DNSAllowLabel: "example.com"}, "badexample.com.", false},
+		{"wildcard doesn't match the domain itself", map[string]string{common.DNSAllowLabel: "*.example.com"}, "example.com.", false},
+		{"wildcard matches subdomains", map[string]string{common.DNSAllowLabel: "*.example.com"}, "a.b.example.com.", true},
+		{"case insensitive", map[string]string{common.DNSAllowLabel: "Example.COM."}, "API.example.com.", true},
+		{"list of patterns", map[string]string{common.DNSAllowLabel: " example.org , example.com"}, "example.com.", true},
+		{"denied domain", map[string]string{common.DNSDenyLabel: "example.com"}, "api.example.com.", false},
+		{"deny takes precedence", map[string]string{common.DNSAllowLabel: "*", common.DNSDenyLabel: "tracker.example.com"}, "tracker.example.com.", false},
+		{"deny all", map[string]string{common.DNSDenyLabel: "*"}, "example.com.", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := NewQueryPolicy(test.labels).IsAllowed(test.query); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestQueryPolicyGetDenyRcode(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   QueryPolicy
+		expected int
+	}{
+		{"zero value", QueryPolicy{}, dns.RcodeRefused},
+		{"no label", NewQueryPolicy(nil), dns.RcodeRefused},
+		{"refused", NewQueryPolicy(map[string]string{common.DNSDenyResponseLabel: "REFUSED"}), dns.RcodeRefused},
+		{"nxdomain", NewQueryPolicy(map[string]string{common.DNSDenyResponseLabel: "nxdomain"}), dns.RcodeNameError},
+		{"invalid value", NewQueryPolicy(map[string]string{common.DNSDenyResponseLabel: "noerror"}), dns.RcodeRefused},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.policy.GetDenyRcode(); actual != test.expected {
+				t.Errorf("expected %s, got %s", dns.RcodeToString[test.expected], dns.RcodeToString[actual])
+			}
+		})
+	}
+}
+
+func TestServeDNSAppliesPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		labels          map[string]string
+		expectedRcode   int
+		expectedAnswers int
+		forwarded       bool
+	}{
+		{"allowed external name", "example.com.", nil, dns.RcodeSuccess, 1, true},
+		{"denied external name", "example.com.", map[string]string{common.DNSDenyLabel: "example.com"}, dns.RcodeRefused, 0, false},
+		{"internal names are always resolved", "app.", map[string]string{common.DNSDenyLabel: "*"}, dns.RcodeSuccess, 1, false},
+		{"allowed CNAME target", "www.", map[string]string{common.DNSAllowLabel: "example.com"}, dns.RcodeSuccess, 2, true},
+		{"denied CNAME target", "www.", map[string]string{common.DNSDenyLabel: "example.com"}, dns.RcodeRefused, 1, false},
+		{"denied CNAME target with nxdomain", "www.", map[string]string{common.DNSDenyLabel: "example.com", common.DNSDenyResponseLabel: "nxdomain"}, dns.RcodeNameError, 1, false},
+		{"denied CNAME target with allow list", "www.", map[string]string{common.DNSAllowLabel: "example.org"}, dns.RcodeRefused, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, forwarder := newTestNameserver(DNSConfig{Policy: NewQueryPolicy(test.labels)})
+			n.resolver.SetCustomRecords([]CustomRecord{{Name: "www", Type: CustomRecordTypeCNAME, Target: "web.example.com"}})
+			response := query(n, test.query, dns.TypeA)
+
+			if response.Rcode != test.expectedRcode {
+				t.Errorf("expected rcode %s, got %s", dns.RcodeToString[test.expectedRcode], dns.RcodeToString[response.Rcode])
+			}
+			if len(response.Answer) != test.expectedAnswers {
+				t.Errorf("expected %d answers, got %v", test.expectedAnswers, response.Answer)
+			}
+			if (len(forwarder.forwarded) > 0) != test.forwarded {
+				t.Errorf("expected forwarded %v, got %v", test.forwarded, forwarder.forwarded)
+			}
+		})
+	}
+}
@@ -2,19 +2,31 @@ package dns
 
 import (
 	"fmt"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/miekg/dns"
 	"github.com/samber/lo"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"log"
+	"maps"
 	"net"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
 
+const (
+	tasksPrefix = "tasks."
+	// maxCNAMEDepth limits how many custom CNAME records are followed, to protect against loops
+	maxCNAMEDepth = 8
+)
+
 type Resolver interface {
 	ResolveName(query string, validNetworkIDs []string) []dns.RR
 	ResolveIP(query string, validNetworkIDs []string) []dns.RR
+	// ResolveSRV returns the SRV records and the A records of their targets for queries of the form
+	// _<port name or target port>._<protocol>.<service name>
+	ResolveSRV(query string, validNetworkIDs []string) (answers []dns.RR, extra []dns.RR)
 	AddNetwork(network common.NetworkInfo)
 	RemoveNetwork(network common.NetworkInfo)
 	AddContainer(container common.ContainerInfo)
@@ -22,24 +34,79 @@ type Resolver interface {
 	RemoveContainer(container common.ContainerInfo)
 	AddService(service common.Service)
 	RemoveService(service common.Service)
+	// IsInternalPTR returns true if the PTR query is for an IP in the subnet of one of the known networks
+	IsInternalPTR(query string) bool
+	// GetNetworkIDs returns the IDs of all known networks by their normalized names, i.e. in lower case
+	GetNetworkIDs() map[string]string
+	// SetCustomRecords replaces all custom records. They are only used if no container or service matches
+	SetCustomRecords(records []CustomRecord)
 }
 
 type containerDNSNameData struct {
 	containerID string
-	networkID   string
 	ip          net.IP
 }
 
+type containerIPData struct {
+	containerID   string
+	containerName string
+}
+
+type serviceVIPData struct {
+	serviceID string
+	service   common.Service
+}
+
+type srvTarget struct {
+	name     string
+	ip       net.IP
+	port     uint32
+	ttl      uint32
+	priority uint16
+}
+
+type resolvedIP struct {
+	ip  net.IP
+	ttl uint32
+	// isLocal is only set for tasks on this node of services that want them to be listed first
+	isLocal bool
+}
+
+type resolvedName struct {
+	name string
+	ttl  uint32
+}
+
+// TTLConfig contains the plugin level TTLs of DNS answers. They can be overridden per network via
+// network options and per service via service labels
+type TTLConfig struct {
+	Default uint32
+	// DNSRR is used for services with endpoint mode dnsrr and for tasks.<service name>, because their IPs
+	// change with every update of the service
+	DNSRR uint32
+}
+
+// All names used as keys are normalized, i.e. lower case and without trailing dot, because DNS names
+// are case-insensitive
 type resolver struct {
-	networkNameToID map[string]string
+	networkNameToID map[string]string // normalized network name -> network ID
 	networkIDToName map[string]string
-	containerData   map[string][]containerDNSNameData // dns name -> data
+	networks        map[string]common.NetworkInfo                // network ID -> info
+	containerData   map[string]map[string][]containerDNSNameData // network ID -> normalized dns name -> data
+	containerIPs    map[string]map[string][]containerIPData      // network ID -> IP -> data
+	serviceVIPs     map[string]map[string][]serviceVIPData       // network ID -> VIP -> data
+	subnets         map[string]*net.IPNet                        // network ID -> subnet
+	// The VIPs of a service are set after it has been added, so we subscribe to the events that change them
+	serviceUnsubscribes map[string][]func() // service ID -> unsubscribe functions
 	// We only need the service info here, but store the service instance because it's data will always
 	// be up-to-date
-	serviceData             map[string]common.Service // service name -> data
+	serviceData             map[string]common.Service // normalized service name -> data
+	customRecords           map[string][]CustomRecord // normalized name -> records
 	dockerCompatibilityMode bool
-	ttl                     uint32
-	sync.Mutex
+	ttlConfig               TTLConfig
+	hostname                string
+	// Queries only need a read lock, so they don't block each other
+	sync.RWMutex
 }
 
 // NewResolver
@@ -82,34 +149,133 @@ type resolver struct {
 // Example 4: We get IPs from both networks
 // Example 5: We get the VIP from both networks and the container IP from the web network
 // Example 6: Same as Docker
-// TODO: Add tests
-func NewResolver(dockerCompatibilityMode bool) Resolver {
+// ttlConfig: All records of an answer get the smallest TTL of the matches, because the TTLs of
+// the records of a record set must be the same
+func NewResolver(dockerCompatibilityMode bool, ttlConfig TTLConfig) Resolver {
+	hostname, _ := os.Hostname()
 	return &resolver{
 		networkNameToID:         make(map[string]string),
 		networkIDToName:         make(map[string]string),
+		networks:                make(map[string]common.NetworkInfo),
 		dockerCompatibilityMode: dockerCompatibilityMode,
-		containerData:           make(map[string][]containerDNSNameData),
+		containerData:           make(map[string]map[string][]containerDNSNameData),
+		containerIPs:            make(map[string]map[string][]containerIPData),
+		serviceVIPs:             make(map[string]map[string][]serviceVIPData),
+		subnets:                 make(map[string]*net.IPNet),
+		serviceUnsubscribes:     make(map[string][]func()),
 		serviceData:             make(map[string]common.Service),
-		ttl:                     600,
+		customRecords:           make(map[string][]CustomRecord),
+		ttlConfig:               ttlConfig,
+		hostname:                hostname,
 	}
 }
 
 func (r *resolver) AddService(service common.Service) {
-	r.Lock()
-	defer r.Unlock()
+	// Events are raised while their handlers are locked, so we must not (un)subscribe while holding our lock
+	unsubscribes := []func(){
+		service.Events().OnVIPsChanged.Subscribe(r.updateServiceVIPs),
+		service.Events().OnNetworksChanged.Subscribe(r.updateServiceVIPs),
+		service.Events().OnEndpointModeChanged.Subscribe(r.updateServiceVIPs),
+	}
 
+	r.Lock()
 	serviceInfo := service.GetInfo()
 	fmt.Printf("Adding service to resolver %+v\n", serviceInfo)
-	r.serviceData[serviceInfo.Name] = service
+	r.serviceData[normalizeName(serviceInfo.Name)] = service
+
+	previousUnsubscribes := r.serviceUnsubscribes[serviceInfo.ID]
+	r.serviceUnsubscribes[serviceInfo.ID] = unsubscribes
+	r.removeServiceVIPs(serviceInfo.ID)
+	r.addServiceVIPs(service)
+	r.Unlock()
+
+	for _, unsubscribe := range previousUnsubscribes {
+		unsubscribe()
+	}
 }
 
 func (r *resolver) RemoveService(service common.Service) {
+	r.Lock()
+	serviceInfo := service.GetInfo()
+	fmt.Printf("Removing service from resolver %+v\n", serviceInfo)
+	delete(r.serviceData, normalizeName(serviceInfo.Name))
+
+	unsubscribes := r.serviceUnsubscribes[serviceInfo.ID]
+	delete(r.serviceUnsubscribes, serviceInfo.ID)
+	r.removeServiceVIPs(serviceInfo.ID)
+	r.Unlock()
+
+	for _, unsubscribe := range unsubscribes {
+		unsubscribe()
+	}
+}
+
+func (r *resolver) updateServiceVIPs(service common.Service) {
 	r.Lock()
 	defer r.Unlock()
 
+	serviceID := service.GetInfo().ID
+	if _, exists := r.serviceUnsubscribes[serviceID]; !exists {
+		// The service has been removed in the meantime
+		return
+	}
+	r.removeServiceVIPs(serviceID)
+	r.addServiceVIPs(service)
+}
+
+// addServiceVIPs adds the VIPs of the service to the index that is used to answer PTR queries. Only services
+// with endpoint mode VIP have VIPs that resolve to them
+func (r *resolver) addServiceVIPs(service common.Service) {
 	serviceInfo := service.GetInfo()
-	fmt.Printf("Removing service from resolver %+v\n", serviceInfo)
-	delete(r.serviceData, serviceInfo.Name)
+	if serviceInfo.EndpointMode != common.ServiceEndpointModeVip {
+		return
+	}
+	for networkID, vip := range serviceInfo.VIPs {
+		networkData, exists := r.serviceVIPs[networkID]
+		if !exists {
+			networkData = make(map[string][]serviceVIPData)
+			r.serviceVIPs[networkID] = networkData
+		}
+		add(networkData, vip.String(), serviceVIPData{
+			serviceID: serviceInfo.ID,
+			service:   service,
+		})
+	}
+}
+
+func (r *resolver) removeServiceVIPs(serviceID string) {
+	for networkID, networkData := range r.serviceVIPs {
+		for vip := range networkData {
+			remove(networkData, vip, func(item serviceVIPData) bool {
+				return item.serviceID == serviceID
+			})
+		}
+		if len(networkData) == 0 {
+			delete(r.serviceVIPs, networkID)
+		}
+	}
+}
+
+func (r *resolver) GetNetworkIDs() map[string]string {
+	r.RLock()
+	defer r.RUnlock()
+
+	return maps.Clone(r.networkNameToID)
+}
+
+func (r *resolver) SetCustomRecords(records []CustomRecord) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.customRecords = make(map[string][]CustomRecord)
+	for _, record := range records {
+		if err := record.Validate(); err != nil {
+			log.Printf("Ignoring invalid custom DNS record: %v", err)
+			continue
+		}
+		name := normalizeName(record.Name)
+		r.customRecords[name] = append(r.customRecords[name], record)
+	}
 }
 
 func (r *resolver) AddNetwork(network common.NetworkInfo) {
@@ -117,8 +283,17 @@ func (r *resolver) AddNetwork(network common.NetworkInfo) {
 	defer r.Unlock()
 
 	fmt.Printf("Adding network to resolver %+v\n", network)
-	r.networkNameToID[network.Name] = network.DockerID
+	if previousName, exists := r.networkIDToName[network.DockerID]; exists && previousName != network.Name {
+		delete(r.networkNameToID, normalizeName(previousName))
+	}
+	r.networkNameToID[normalizeName(network.Name)] = network.DockerID
 	r.networkIDToName[network.DockerID] = network.Name
+	r.networks[network.DockerID] = network
+	if _, subnet, err := net.ParseCIDR(network.Subnet); err == nil {
+		r.subnets[network.DockerID] = subnet
+	} else {
+		delete(r.subnets, network.DockerID)
+	}
 }
 
 func (r *resolver) RemoveNetwork(network common.NetworkInfo) {
@@ -126,8 +301,22 @@ func (r *resolver) RemoveNetwork(network common.NetworkInfo) {
 	defer r.Unlock()
 
 	fmt.Printf("Removing network from resolver %+v\n", network)
-	delete(r.networkNameToID, network.DockerID)
-	delete(r.networkIDToName, network.Name)
+	delete(r.networkNameToID, normalizeName(network.Name))
+	delete(r.networkIDToName, network.DockerID)
+	delete(r.networks, network.DockerID)
+	delete(r.subnets, network.DockerID)
+}
+
+func (r *resolver) IsInternalPTR(query string) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	ip := ptrQueryToIP(query)
+	if ip == nil {
+		return false
+	}
+
+	return lo.SomeBy(lo.Values(r.subnets), func(subnet *net.IPNet) bool { return subnet.Contains(ip) })
 }
 
 func (r *resolver) AddContainer(container common.ContainerInfo) {
@@ -137,13 +326,10 @@ func (r *resolver) AddContainer(container common.ContainerInfo) {
 	fmt.Printf("Adding container to resolver %+v\n", container)
 	for networkID, dnsNames := range container.DNSNames {
 		for _, dnsName := range dnsNames {
-			add(r.containerData, dnsName, containerDNSNameData{
-				networkID:   networkID,
-				ip:          container.IPs[networkID],
-				containerID: container.ID,
-			})
+			r.addContainerDNSName(networkID, normalizeName(dnsName), container)
 		}
 	}
+	r.addContainerIPs(container)
 }
 
 func (r *resolver) RemoveContainer(container common.ContainerInfo) {
@@ -151,13 +337,12 @@ func (r *resolver) RemoveContainer(container common.ContainerInfo) {
 	defer r.Unlock()
 
 	fmt.Printf("Removing container from resolver %+v\n", container)
-	for _, dnsNames := range container.DNSNames {
+	for networkID, dnsNames := range container.DNSNames {
 		for _, dnsName := range dnsNames {
-			remove(r.containerData, dnsName, func(item containerDNSNameData) bool {
-				return item.containerID == container.ID
-			})
+			r.removeContainerDNSName(networkID, normalizeName(dnsName), container.ID)
 		}
 	}
+	r.removeContainerIPs(container.ID)
 }
 
 func (r *resolver) UpdateContainer(container common.ContainerInfo) {
@@ -168,21 +353,24 @@ func (r *resolver) UpdateContainer(container common.ContainerInfo) {
 
 	knownDnsNames := make(map[string]map[string]struct{}) // network ID -> dns names
 
-	for dnsName, data := range r.containerData {
-		for _, item := range data {
-			if item.containerID == container.ID {
-				networkData, exists := knownDnsNames[item.networkID]
-				if !exists {
-					networkData = make(map[string]struct{})
-					knownDnsNames[item.networkID] = networkData
+	for networkID, networkData := range r.containerData {
+		for dnsName, data := range networkData {
+			for _, item := range data {
+				if item.containerID == container.ID {
+					dnsNames, exists := knownDnsNames[networkID]
+					if !exists {
+						dnsNames = make(map[string]struct{})
+						knownDnsNames[networkID] = dnsNames
+					}
+					dnsNames[dnsName] = struct{}{}
 				}
-				networkData[dnsName] = struct{}{}
 			}
 		}
 	}
 
 	for networkID, dnsNames := range container.DNSNames {
 		for _, dnsName := range dnsNames {
+			dnsName = normalizeName(dnsName)
 			networkData, exists := knownDnsNames[networkID]
 			needsToBeAdded := true
 			if exists {
@@ -193,38 +381,89 @@ func (r *resolver) UpdateContainer(container common.ContainerInfo) {
 			}
 
 			if needsToBeAdded {
-				add(r.containerData, dnsName, containerDNSNameData{
-					networkID:   networkID,
-					ip:          container.IPs[networkID],
-					containerID: container.ID,
-				})
+				r.addContainerDNSName(networkID, dnsName, container)
 			}
 		}
 	}
 
 	for networkID, dnsNames := range knownDnsNames {
 		for dnsName, _ := range dnsNames {
-			remove(r.containerData, dnsName, func(item containerDNSNameData) bool {
-				return item.containerID == container.ID && item.networkID == networkID
+			r.removeContainerDNSName(networkID, dnsName, container.ID)
+		}
+	}
+
+	r.removeContainerIPs(container.ID)
+	r.addContainerIPs(container)
+}
+
+func (r *resolver) addContainerDNSName(networkID string, dnsName string, container common.ContainerInfo) {
+	networkData, exists := r.containerData[networkID]
+	if !exists {
+		networkData = make(map[string][]containerDNSNameData)
+		r.containerData[networkID] = networkData
+	}
+	add(networkData, dnsName, containerDNSNameData{
+		ip:          container.IPs[networkID],
+		containerID: container.ID,
+	})
+}
+
+func (r *resolver) removeContainerDNSName(networkID string, dnsName string, containerID string) {
+	networkData, exists := r.containerData[networkID]
+	if !exists {
+		return
+	}
+	remove(networkData, dnsName, func(item containerDNSNameData) bool {
+		return item.containerID == containerID
+	})
+	if len(networkData) == 0 {
+		delete(r.containerData, networkID)
+	}
+}
+
+func (r *resolver) addContainerIPs(container common.ContainerInfo) {
+	for networkID, ip := range container.IPs {
+		networkData, exists := r.containerIPs[networkID]
+		if !exists {
+			networkData = make(map[string][]containerIPData)
+			r.containerIPs[networkID] = networkData
+		}
+		add(networkData, ip.String(), containerIPData{
+			containerID:   container.ID,
+			containerName: container.Name,
+		})
+	}
+}
+
+func (r *resolver) removeContainerIPs(containerID string) {
+	for networkID, networkData := range r.containerIPs {
+		for ip := range networkData {
+			remove(networkData, ip, func(item containerIPData) bool {
+				return item.containerID == containerID
 			})
 		}
+		if len(networkData) == 0 {
+			delete(r.containerIPs, networkID)
+		}
 	}
 }
 
 func (r *resolver) ResolveName(query string, validNetworkIDs []string) []dns.RR {
-	r.Lock()
-	defer r.Unlock()
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.resolveNameRecords(query, validNetworkIDs, 0)
+}
 
-	queryParts := strings.Split(strings.TrimSuffix(query, "."), ".") // Remove trailing . in queries
+// resolveNameRecords resolves the query to the containers and services and - if there is no match - to the
+// custom records. depth is the number of custom CNAME records that have already been followed
+func (r *resolver) resolveNameRecords(query string, validNetworkIDs []string, depth int) []dns.RR {
+	queryParts := strings.Split(normalizeName(query), ".")
 	namePartsCount := len(queryParts)
 
-	sortedNetworkIDs := make([]string, len(validNetworkIDs))
-	copy(sortedNetworkIDs, validNetworkIDs)
-	sort.Slice(sortedNetworkIDs, func(i, j int) bool {
-		return r.networkIDToName[validNetworkIDs[i]] < r.networkIDToName[validNetworkIDs[j]]
-	})
+	sortedNetworkIDs := r.sortNetworkIDs(validNetworkIDs)
 
-	result := []net.IP{}
+	result := []resolvedIP{}
 
 	for i := 0; i < len(queryParts); i++ {
 		requestedName := strings.Join(queryParts[:namePartsCount-i], ".")
@@ -241,55 +480,287 @@ func (r *resolver) ResolveName(query string, validNetworkIDs []string) []dns.RR
 			break
 		}
 	}
-	result = lo.Shuffle(lo.UniqBy(result, func(item net.IP) string {
-		return item.String()
+	ttl := minTTL(result, func(item resolvedIP) uint32 { return item.ttl })
+	result = lo.Shuffle(lo.UniqBy(result, func(item resolvedIP) string {
+		return item.ip.String()
 	}))
-	dnsRecords := lo.Map(result, func(item net.IP, index int) dns.RR {
-		return &dns.A{
+	sort.SliceStable(result, func(i, j int) bool { return result[i].isLocal && !result[j].isLocal })
+	if len(result) == 0 {
+		return r.resolveCustomRecords(query, validNetworkIDs, depth)
+	}
+
+	return toARecords(query, lo.Map(result, func(item resolvedIP, _ int) net.IP { return item.ip }), ttl)
+}
+
+// resolveCustomRecords returns the A records or the CNAME record for the query. Records of the valid networks
+// shadow global records of the same name. CNAMEs are followed as long as their target can be resolved
+// internally. Otherwise, the answer only contains the CNAME and the target is left to the upstream servers
+func (r *resolver) resolveCustomRecords(query string, validNetworkIDs []string, depth int) []dns.RR {
+	records := lo.Filter(r.customRecords[normalizeName(query)], func(record CustomRecord, _ int) bool {
+		return record.Network == "" || lo.SomeBy(validNetworkIDs, func(networkID string) bool {
+			return record.Network == networkID || strings.EqualFold(record.Network, r.networkIDToName[networkID])
+		})
+	})
+	networkSpecificRecords := lo.Filter(records, func(record CustomRecord, _ int) bool { return record.Network != "" })
+	if len(networkSpecificRecords) > 0 {
+		records = networkSpecificRecords
+	}
+	if len(records) == 0 {
+		return []dns.RR{}
+	}
+
+	cname, isCNAME := lo.Find(records, func(record CustomRecord) bool { return record.Type == CustomRecordTypeCNAME })
+	if isCNAME {
+		if depth >= maxCNAMEDepth {
+			log.Printf("Not following custom CNAME record %s, because the maximum depth of %d has been reached", cname.Name, maxCNAMEDepth)
+			return []dns.RR{}
+		}
+		target := dns.Fqdn(cname.Target)
+		result := []dns.RR{&dns.CNAME{
 			Hdr: dns.RR_Header{
 				Name:   query,
+				Rrtype: dns.TypeCNAME,
+				Class:  dns.ClassINET,
+				Ttl:    r.getCustomRecordTTL(cname),
+			},
+			Target: target,
+		}}
+		return append(result, r.resolveNameRecords(target, validNetworkIDs, depth+1)...)
+	}
+
+	ips := lo.UniqBy(lo.FlatMap(records, func(record CustomRecord, _ int) []net.IP { return record.IPs }),
+		func(ip net.IP) string { return ip.String() })
+
+	return toARecords(query, lo.Shuffle(ips), minTTL(records, r.getCustomRecordTTL))
+}
+
+func (r *resolver) getCustomRecordTTL(record CustomRecord) uint32 {
+	if record.TTL > 0 {
+		return record.TTL
+	}
+
+	return r.ttlConfig.Default
+}
+
+func toARecords(name string, ips []net.IP, ttl uint32) []dns.RR {
+	return lo.Map(ips, func(ip net.IP, _ int) dns.RR {
+		return &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   name,
 				Rrtype: dns.TypeA,
 				Class:  dns.ClassINET,
-				Ttl:    r.ttl,
+				Ttl:    ttl,
 			},
-			A: item,
+			A: ip,
 		}
 	})
+}
+
+// ResolveIP answers PTR queries of the form 4.3.2.1.in-addr.arpa.
+// Container IPs resolve to <container name>.<network name>, service VIPs to <service name>.<network name>.
+// This matches what the internal docker DNS returns. DNSRR services have no VIP, their task IPs are
+// container IPs and are therefore resolved to the names of the tasks.
+// In dockerCompatibilityMode, only the first match is returned, otherwise all matches of all valid networks
+func (r *resolver) ResolveIP(query string, validNetworkIDs []string) []dns.RR {
+	r.RLock()
+	defer r.RUnlock()
+
+	ip := ptrQueryToIP(query)
+	if ip == nil {
+		return []dns.RR{}
+	}
+
+	sortedNetworkIDs := r.sortNetworkIDs(validNetworkIDs)
 
-	fmt.Printf("Received request to resolve %s in networks %v. Resolved to %v\n", query, validNetworkIDs, dnsRecords)
-	if len(dnsRecords) == 0 {
-		fmt.Printf("Available data:\n%s\n", spew.Sdump(r))
+	result := []resolvedName{}
+	for _, networkID := range sortedNetworkIDs {
+		result = append(result, r.resolveIP(ip, networkID)...)
+		if r.dockerCompatibilityMode && len(result) > 0 {
+			result = result[:1]
+			break
+		}
 	}
 
-	return dnsRecords
+	ttl := minTTL(result, func(item resolvedName) uint32 { return item.ttl })
+	result = lo.UniqBy(result, func(item resolvedName) string { return item.name })
+	return lo.Map(result, func(item resolvedName, index int) dns.RR {
+		return &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   query,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Ptr: dns.Fqdn(item.name),
+		}
+	})
 }
 
-func (r *resolver) ResolveIP(query string, validNetworkIDs []string) []dns.RR {
-	r.Lock()
-	defer r.Unlock()
+// resolveIP returns the names of all containers with the IP in the specified network
+// and the names of all services with the IP as their VIP in the specified network
+func (r *resolver) resolveIP(ip net.IP, validNetworkID string) []resolvedName {
+	result := []resolvedName{}
+	networkName, exists := r.networkIDToName[validNetworkID]
+	if !exists {
+		return result
+	}
 
-	// TODO: Implement
-	return []dns.RR{}
+	for _, data := range r.containerIPs[validNetworkID][ip.String()] {
+		result = append(result, resolvedName{
+			name: fmt.Sprintf("%s.%s", data.containerName, networkName),
+			ttl:  r.getContainerTTL(validNetworkID),
+		})
+	}
+
+	for _, data := range r.serviceVIPs[validNetworkID][ip.String()] {
+		serviceInfo := data.service.GetInfo()
+		result = append(result, resolvedName{
+			name: fmt.Sprintf("%s.%s", serviceInfo.Name, networkName),
+			ttl:  r.getServiceTTL(serviceInfo, validNetworkID, false),
+		})
+	}
+
+	return result
+}
+
+// ResolveSRV uses the same variations of service and network name as ResolveName.
+// For services with endpoint mode "vip", the target is the VIP of the service. For services with endpoint mode
+// "dnsrr", there is one target per container of the service.
+// The targets are of the form <service or container name>.<network name>
+func (r *resolver) ResolveSRV(query string, validNetworkIDs []string) (answers []dns.RR, extra []dns.RR) {
+	r.RLock()
+	defer r.RUnlock()
+
+	answers = []dns.RR{}
+	extra = []dns.RR{}
+
+	labels := dns.SplitDomainName(normalizeName(query))
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return
+	}
+	portName := strings.TrimPrefix(labels[0], "_")
+	protocol := strings.TrimPrefix(labels[1], "_")
+	nameParts := labels[2:]
+	namePartsCount := len(nameParts)
+
+	sortedNetworkIDs := r.sortNetworkIDs(validNetworkIDs)
+
+	targets := []srvTarget{}
+
+	for i := 0; i < namePartsCount; i++ {
+		requestedName := strings.Join(nameParts[:namePartsCount-i], ".")
+		requestedNetworkName := strings.Join(nameParts[namePartsCount-i:], ".")
+
+		for _, networkID := range sortedNetworkIDs {
+			if r.isRequestedNetwork(requestedNetworkName, networkID) {
+				targets = append(targets, r.resolveServiceTargets(requestedName, networkID, portName, protocol)...)
+			}
+			if r.dockerCompatibilityMode && len(targets) > 0 {
+				break
+			}
+		}
+
+		if len(targets) > 0 {
+			break
+		}
+	}
+
+	targets = lo.UniqBy(targets, func(item srvTarget) string {
+		return fmt.Sprintf("%s:%d", item.name, item.port)
+	})
+
+	ttl := minTTL(targets, func(item srvTarget) uint32 { return item.ttl })
+	for _, target := range targets {
+		answers = append(answers, &dns.SRV{
+			Hdr: dns.RR_Header{
+				Name:   query,
+				Rrtype: dns.TypeSRV,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Priority: target.priority,
+			Weight:   10,
+			Port:     uint16(target.port),
+			Target:   dns.Fqdn(target.name),
+		})
+	}
+
+	for _, target := range lo.UniqBy(targets, func(item srvTarget) string { return item.name }) {
+		extra = append(extra, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   dns.Fqdn(target.name),
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: target.ip,
+		})
+	}
+
+	return
+}
+
+// resolveServiceTargets returns the SRV targets of the ports of the service that match the port name - which
+// can also be the target port - and the protocol
+func (r *resolver) resolveServiceTargets(serviceName string, validNetworkID string, portName string, protocol string) []srvTarget {
+	result := []srvTarget{}
+
+	service, exists := r.serviceData[serviceName]
+	if !exists {
+		return result
+	}
+	networkName := r.networkIDToName[validNetworkID]
+	serviceInfo := service.GetInfo()
+
+	for _, port := range serviceInfo.Ports {
+		if !strings.EqualFold(port.Protocol, protocol) {
+			continue
+		}
+		if !strings.EqualFold(port.Name, portName) && strconv.FormatUint(uint64(port.TargetPort), 10) != portName {
+			continue
+		}
+
+		if serviceInfo.EndpointMode == common.ServiceEndpointModeVip {
+			for _, vip := range filterIPsByNetwork(serviceInfo.VIPs, validNetworkID) {
+				result = append(result, srvTarget{
+					name: fmt.Sprintf("%s.%s", serviceInfo.Name, networkName),
+					ip:   vip,
+					port: port.TargetPort,
+					ttl:  r.getServiceTTL(serviceInfo, validNetworkID, false),
+				})
+			}
+		} else if serviceInfo.EndpointMode == common.ServiceEndpointModeDnsrr {
+			containers, orderLocalFirst := r.preferLocalContainers(serviceInfo, healthyContainers(serviceInfo.Containers))
+			for _, container := range containers {
+				// Clients try targets with a lower priority first
+				priority := uint16(0)
+				if orderLocalFirst && container.Node != r.hostname {
+					priority = 1
+				}
+				for _, ip := range filterIPsByNetwork(container.IPs, validNetworkID) {
+					result = append(result, srvTarget{
+						name:     fmt.Sprintf("%s.%s", container.Name, networkName),
+						ip:       ip,
+						port:     port.TargetPort,
+						ttl:      r.getServiceTTL(serviceInfo, validNetworkID, false),
+						priority: priority,
+					})
+				}
+			}
+		}
+	}
+
+	return result
 }
 
 // resolveName returns the IP of the first valid network alphabetically speaking for a matching container
 // and the VIP of the first valid network for a matching service with endpoint mode "vip"
 // If the endpoint mode is "dnsrr" it returns the IP of the first valid network for each container
 // of the matching service
-func (r *resolver) resolveName(requestedName string, requestedNetworkName string, validNetworkID string) []net.IP {
-	result := []net.IP{}
-	if requestedNetworkName != "" {
-		networkID, exists := r.networkNameToID[requestedNetworkName]
-		if !exists {
-			fmt.Printf("Network Name %s does not exist\n", requestedNetworkName)
-			// No network with this name exists, so there can't be a match
-			return result
-		}
-		if validNetworkID != networkID {
-			fmt.Printf("Network ID %s is not valid. Expected: %s\n", networkID, validNetworkID)
-			// While the network exists, it's not the valid network, so there can't be a match
-			return result
-		}
+func (r *resolver) resolveName(requestedName string, requestedNetworkName string, validNetworkID string) []resolvedIP {
+	result := []resolvedIP{}
+	if !r.isRequestedNetwork(requestedNetworkName, validNetworkID) {
+		return result
 	}
 
 	result = append(result, r.resolveServiceName(requestedName, validNetworkID)...)
@@ -298,37 +769,178 @@ func (r *resolver) resolveName(requestedName string, requestedNetworkName string
 	return result
 }
 
-func (r *resolver) resolveContainerName(requestedName string, validNetworkID string) []net.IP {
-	result := []net.IP{}
-	dnsNameData, exists := r.containerData[requestedName]
-	if exists {
-		for _, data := range dnsNameData {
-			if validNetworkID == data.networkID {
-				result = append(result, data.ip)
-			}
-		}
+// isRequestedNetwork returns true if no network has been requested or if the requested network is the valid network
+func (r *resolver) isRequestedNetwork(requestedNetworkName string, validNetworkID string) bool {
+	if requestedNetworkName == "" {
+		return true
+	}
+
+	networkID, exists := r.networkNameToID[normalizeName(requestedNetworkName)]
+	if !exists {
+		// No network with this name exists, so there can't be a match
+		return false
+	}
+	if validNetworkID != networkID {
+		// While the network exists, it's not the valid network, so there can't be a match
+		return false
+	}
+
+	return true
+}
+
+func (r *resolver) resolveContainerName(requestedName string, validNetworkID string) []resolvedIP {
+	result := []resolvedIP{}
+	for _, data := range r.containerData[validNetworkID][requestedName] {
+		result = append(result, resolvedIP{ip: data.ip, ttl: r.getContainerTTL(validNetworkID)})
 	}
 
 	return result
 }
 
-func (r *resolver) resolveServiceName(requestedName string, validNetworkID string) []net.IP {
-	result := []net.IP{}
+// resolveServiceName also supports the special name tasks.<service name> which - same as in docker -
+// returns the IPs of all containers of the service, independent of its endpoint mode
+func (r *resolver) resolveServiceName(requestedName string, validNetworkID string) []resolvedIP {
+	result := []resolvedIP{}
 
 	service, exists := r.serviceData[requestedName]
+	if !exists && strings.HasPrefix(requestedName, tasksPrefix) {
+		service, exists = r.serviceData[strings.TrimPrefix(requestedName, tasksPrefix)]
+		if exists {
+			serviceInfo := service.GetInfo()
+			ttl := r.getServiceTTL(serviceInfo, validNetworkID, true)
+			containers, orderLocalFirst := r.preferLocalContainers(serviceInfo, healthyContainers(serviceInfo.Containers))
+			for _, container := range containers {
+				result = append(result, r.toResolvedTaskIPs(container, validNetworkID, ttl, orderLocalFirst)...)
+			}
+		}
+		return result
+	}
 	if exists {
 		serviceInfo := service.GetInfo()
+		ttl := r.getServiceTTL(serviceInfo, validNetworkID, false)
 		if serviceInfo.EndpointMode == common.ServiceEndpointModeVip {
-			result = append(result, filterIPsByNetwork(serviceInfo.VIPs, validNetworkID)...)
+			result = append(result, toResolvedIPs(filterIPsByNetwork(serviceInfo.VIPs, validNetworkID), ttl)...)
 		} else if serviceInfo.EndpointMode == common.ServiceEndpointModeDnsrr {
-			for _, container := range serviceInfo.Containers {
-				result = append(result, filterIPsByNetwork(container.IPs, validNetworkID)...)
+			containers, orderLocalFirst := r.preferLocalContainers(serviceInfo, healthyContainers(serviceInfo.Containers))
+			for _, container := range containers {
+				result = append(result, r.toResolvedTaskIPs(container, validNetworkID, ttl, orderLocalFirst)...)
 			}
 		}
 	}
 	return result
 }
 
+// preferLocalContainers applies the label flannel-np.dns-prefer-local of the service. With "filter", only the
+// containers on this node are returned - or all containers if there are none on this node. With "order", all
+// containers are returned and the second return value is true, so the caller lists the local ones first.
+// The querying container always runs on this node, because every node resolves the queries of its own containers
+func (r *resolver) preferLocalContainers(serviceInfo common.ServiceInfo, containers []common.ContainerInfo) ([]common.ContainerInfo, bool) {
+	switch serviceInfo.Labels[common.DNSPreferLocalLabel] {
+	case common.DNSPreferLocalOrder:
+		return containers, true
+	case common.DNSPreferLocalFilter:
+		local := lo.Filter(containers, func(container common.ContainerInfo, _ int) bool { return container.Node == r.hostname })
+		if len(local) > 0 {
+			return local, false
+		}
+	}
+
+	return containers, false
+}
+
+func (r *resolver) toResolvedTaskIPs(container common.ContainerInfo, validNetworkID string, ttl uint32, orderLocalFirst bool) []resolvedIP {
+	result := toResolvedIPs(filterIPsByNetwork(container.IPs, validNetworkID), ttl)
+	if orderLocalFirst && container.Node == r.hostname {
+		for i := range result {
+			result[i].isLocal = true
+		}
+	}
+
+	return result
+}
+
+// healthyContainers omits containers whose healthcheck reports them as unhealthy or still starting.
+// Containers without healthcheck are considered healthy. If no container is healthy, all containers
+// are returned, because a possibly broken answer is still better than no answer at all
+func healthyContainers(containers map[string]common.ContainerInfo) []common.ContainerInfo {
+	all := lo.Values(containers)
+	healthy := lo.Filter(all, func(container common.ContainerInfo, _ int) bool {
+		return container.Health != common.ContainerHealthUnhealthy && container.Health != common.ContainerHealthStarting
+	})
+	if len(healthy) == 0 {
+		return all
+	}
+
+	return healthy
+}
+
+func (r *resolver) getContainerTTL(networkID string) uint32 {
+	if network, exists := r.networks[networkID]; exists && network.DNSTTL > 0 {
+		return network.DNSTTL
+	}
+
+	return r.ttlConfig.Default
+}
+
+// getServiceTTL returns the TTL from the service label, the network option or the plugin level setting,
+// in that order. The DNSRR TTLs are used for services with endpoint mode dnsrr and for task IPs
+func (r *resolver) getServiceTTL(serviceInfo common.ServiceInfo, networkID string, isTaskIPs bool) uint32 {
+	if ttl := common.ParseUint32Label(serviceInfo.Labels, common.DNSTTLLabel); ttl > 0 {
+		return ttl
+	}
+
+	if isTaskIPs || serviceInfo.EndpointMode == common.ServiceEndpointModeDnsrr {
+		if network, exists := r.networks[networkID]; exists && network.DNSRRTTL > 0 {
+			return network.DNSRRTTL
+		}
+		return r.ttlConfig.DNSRR
+	}
+
+	return r.getContainerTTL(networkID)
+}
+
+// sortNetworkIDs returns a copy of the network IDs, sorted alphabetically by the names of the networks
+func (r *resolver) sortNetworkIDs(networkIDs []string) []string {
+	sortedNetworkIDs := make([]string, len(networkIDs))
+	copy(sortedNetworkIDs, networkIDs)
+	sort.Slice(sortedNetworkIDs, func(i, j int) bool {
+		return r.networkIDToName[sortedNetworkIDs[i]] < r.networkIDToName[sortedNetworkIDs[j]]
+	})
+
+	return sortedNetworkIDs
+}
+
+// ptrQueryToIP converts a query of the form 4.3.2.1.in-addr.arpa. to the IP 1.2.3.4
+// It returns nil if the query isn't a valid IPv4 PTR query
+func ptrQueryToIP(query string) net.IP {
+	query = strings.ToLower(strings.TrimSuffix(query, "."))
+	if !strings.HasSuffix(query, ".in-addr.arpa") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSuffix(query, ".in-addr.arpa"), ".")
+	if len(parts) != 4 {
+		return nil
+	}
+	lo.Reverse(parts)
+
+	return net.ParseIP(strings.Join(parts, ".")).To4()
+}
+
+func toResolvedIPs(ips []net.IP, ttl uint32) []resolvedIP {
+	return lo.Map(ips, func(item net.IP, index int) resolvedIP {
+		return resolvedIP{ip: item, ttl: ttl}
+	})
+}
+
+// minTTL returns the smallest TTL of the items or 0 if there are no items
+func minTTL[T any](items []T, getTTL func(item T) uint32) uint32 {
+	if len(items) == 0 {
+		return 0
+	}
+
+	return lo.Min(lo.Map(items, func(item T, index int) uint32 { return getTTL(item) }))
+}
+
 func filterIPsByNetwork(ips map[string]net.IP, validNetworkID string) []net.IP {
 	result := []net.IP{}
 	for networkID, ip := range ips {
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"net"
+	"testing"
+)
+
+func newTestResolver() (Resolver, common.Service) {
+	r := NewResolver(false, TTLConfig{Default: 600, DNSRR: 5})
+	r.AddNetwork(common.NetworkInfo{DockerID: "n1", FlannelID: "f1", Name: "web", Subnet: "10.1.0.0/16"})
+	r.AddNetwork(common.NetworkInfo{DockerID: "n2", FlannelID: "f2", Name: "internal", Subnet: "10.2.0.0/16"})
+	r.AddContainer(common.ContainerInfo{
+		ID:       "c1",
+		Name:     "app.1.abc",
+		IPs:      map[string]net.IP{"n1": net.ParseIP("10.1.0.5"), "n2": net.ParseIP("10.2.0.5")},
+		DNSNames: map[string][]string{"n1": {"app.1.abc"}, "n2": {"app.1.abc"}},
+	})
+
+	service := common.NewService("s1", "app")
+	service.SetEndpointMode(common.ServiceEndpointModeVip)
+	service.SetNetworks([]string{"n1"}, map[string]net.IP{"n1": net.ParseIP("10.1.0.2")})
+	service.SetVIPs(map[string]net.IP{"n1": net.ParseIP("10.1.0.2")})
+	r.AddService(service)
+
+	return r, service
+}
+
+func ptrNames(records []dns.RR) []string {
+	result := []string{}
+	for _, record := range records {
+		result = append(result, record.(*dns.PTR).Ptr)
+	}
+	return result
+}
+
+func TestResolveIP(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		validNetworkIDs []string
+		expected        []string
+	}{
+		{"container IP", "5.0.1.10.in-addr.arpa.", []string{"n1", "n2"}, []string{"app.1.abc.web."}},
+		{"container IP of other network", "5.0.2.10.in-addr.arpa.", []string{"n1", "n2"}, []string{"app.1.abc.internal."}},
+		{"container IP of invalid network", "5.0.2.10.in-addr.arpa.", []string{"n1"}, []string{}},
+		{"service VIP", "2.0.1.10.in-addr.arpa.", []string{"n1"}, []string{"app.web."}},
+		{"case insensitive", "2.0.1.10.IN-ADDR.ARPA.", []string{"n1"}, []string{"app.web."}},
+		{"unknown IP", "9.0.1.10.in-addr.arpa.", []string{"n1"}, []string{}},
+		{"invalid query", "1.10.in-addr.arpa.", []string{"n1"}, []string{}},
+	}
+
+	r, _ := newTestResolver()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := ptrNames(r.ResolveIP(test.query, test.validNetworkIDs))
+			if len(actual) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != test.expected[i] {
+					t.Fatalf("expected %v, got %v", test.expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveIPFollowsServiceChanges(t *testing.T) {
+	r, service := newTestResolver()
+
+	service.SetVIPs(map[string]net.IP{"n1": net.ParseIP("10.1.0.3")})
+	if actual := ptrNames(r.ResolveIP("2.0.1.10.in-addr.arpa.", []string{"n1"})); len(actual) != 0 {
+		t.Errorf("expected the previous VIP to be removed from the index, got %v", actual)
+	}
+	if actual := ptrNames(r.ResolveIP("3.0.1.10.in-addr.arpa.", []string{"n1"})); len(actual) != 1 || actual[0] != "app.web." {
+		t.Errorf("expected the new VIP to resolve to app.web., got %v", actual)
+	}
+
+	r.RemoveService(service)
+	if actual := ptrNames(r.ResolveIP("3.0.1.10.in-addr.arpa.", []string{"n1"})); len(actual) != 0 {
+		t.Errorf("expected no names after the service was removed, got %v", actual)
+	}
+
+	// Events of removed services must not add them to the index again
+	service.SetVIPs(map[string]net.IP{"n1": net.ParseIP("10.1.0.4")})
+	if actual := ptrNames(r.ResolveIP("4.0.1.10.in-addr.arpa.", []string{"n1"})); len(actual) != 0 {
+		t.Errorf("expected no names for a VIP of a removed service, got %v", actual)
+	}
+}
+
+func TestIsInternalPTR(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"9.0.1.10.in-addr.arpa.", true},
+		{"9.9.2.10.in-addr.arpa.", true},
+		{"9.0.3.10.in-addr.arpa.", false},
+		{"8.8.8.8.in-addr.arpa.", false},
+		{"example.com.", false},
+	}
+
+	r, _ := newTestResolver()
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			if actual := r.IsInternalPTR(test.query); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
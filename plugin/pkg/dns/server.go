@@ -17,6 +17,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,21 +26,43 @@ import (
 const (
 	SandboxesPath = "/hostfs/var/run/flannel-np/sandboxes"
 	ReadyPath     = "/hostfs/var/run/flannel-np/ready"
+	// internalZone is the owner of the SOA records in NODATA answers for internal names. The names of services
+	// and containers aren't part of a real zone, so we use a fixed apex instead of making every name one
+	internalZone = "flannel-np.internal."
 )
 
+type NameserverConfig struct {
+	// ListenIP is the IP inside the network namespace of the container on which the DNS servers listen
+	ListenIP string
+	// ListenPort is the port of the DNS servers for UDP and TCP. 0 means a random port per protocol
+	ListenPort int
+	// Interception is the mechanism that redirects the DNS traffic to 127.0.0.11:53 to the DNS servers,
+	// InterceptionIptables or InterceptionNftables
+	Interception string
+}
+
 type Nameserver interface {
 	Activate(dockerData docker.Data) <-chan error
 	DeactivateAndCleanup() error
-	AddValidNetworkID(validNetworkID string)
-	RemoveValidNetworkID(validNetworkID string)
+	// AddValidNetworkID makes the names of the network resolvable. ip is the address of the container in the
+	// network. With the node-wide DNS server, it identifies the queries of the container. It may be nil
+	AddValidNetworkID(validNetworkID string, ip net.IP)
+	RemoveValidNetworkID(validNetworkID string, ip net.IP)
+	// SetDNSConfig sets the DNS configuration of the container. If it has no servers,
+	// the default upstream servers of the node will be used
+	SetDNSConfig(dnsConfig DNSConfig)
 }
 
 type nameserver struct {
 	networkNamespace string
+	config           NameserverConfig
 	resolver         Resolver
+	forwarder        Forwarder
+	nodeNameserver   NodeNameserver
+	dnsConfig        DNSConfig
+	dnsOptions       dnsOptions
 	portTCP          int
 	portUDP          int
-	listenIP         string
 	tcpServer        *dns.Server
 	udpServer        *dns.Server
 	validNetworkIDs  *common.ConcurrentMap[string, struct{}]
@@ -49,17 +72,17 @@ type nameserver struct {
 	sync.Mutex
 }
 
-// TODO: Add support for dns options on containers, services, docker
-// TODO: Add support for host resolver ???
-// TODO: Check domainname config of containers whether it's relevant
-
 // NewNameserver
 // - networkNamespace: This is expected to be a docker sandbox key of the form /var/run/docker/netns/<key>
-func NewNameserver(networkNamespace string, resolver Resolver, isHookAvailable bool) (Nameserver, error) {
+// - nodeNameserver: If set, no DNS servers are started in the namespace. Instead, the DNS traffic is redirected
+// to the node-wide DNS server which hands the queries of the container to this nameserver
+func NewNameserver(networkNamespace string, config NameserverConfig, resolver Resolver, forwarder Forwarder, nodeNameserver NodeNameserver, isHookAvailable bool) (Nameserver, error) {
 	result := &nameserver{
 		networkNamespace: adjustNamespacePath(networkNamespace),
+		config:           config,
 		resolver:         resolver,
-		listenIP:         "127.0.0.33",
+		forwarder:        forwarder,
+		nodeNameserver:   nodeNameserver,
 		validNetworkIDs:  common.NewConcurrentMap[string, struct{}](),
 		isHookAvailable:  isHookAvailable,
 	}
@@ -74,7 +97,11 @@ func NewNameserver(networkNamespace string, resolver Resolver, isHookAvailable b
 		result.readyFile = filepath.Join(ReadyPath, namespaceKey)
 	}
 
-	result.initManager = common.NewInitManager[docker.Data](result.startDnsServersInNamespace)
+	if nodeNameserver != nil {
+		result.initManager = common.NewInitManager[docker.Data](result.registerWithNodeNameserver)
+	} else {
+		result.initManager = common.NewInitManager[docker.Data](result.startDnsServersInNamespace)
+	}
 
 	return result, nil
 }
@@ -114,7 +141,13 @@ func (n *nameserver) DeactivateAndCleanup() error {
 		n.initManager.Wait()
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
+	if n.nodeNameserver != nil {
+		n.nodeNameserver.Unregister(n)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
 
 	if n.udpServer != nil {
 		if err := n.udpServer.ShutdownContext(ctx); err != nil {
@@ -134,78 +167,242 @@ func (n *nameserver) DeactivateAndCleanup() error {
 	return nil
 }
 
-func (n *nameserver) AddValidNetworkID(validNetworkID string) {
+func (n *nameserver) AddValidNetworkID(validNetworkID string, ip net.IP) {
 	n.Lock()
 	defer n.Unlock()
 
 	n.validNetworkIDs.Set(validNetworkID, struct{}{})
+	if n.nodeNameserver != nil && ip != nil {
+		n.nodeNameserver.Register([]net.IP{ip}, n)
+	}
 }
 
-func (n *nameserver) RemoveValidNetworkID(validNetworkID string) {
+func (n *nameserver) RemoveValidNetworkID(validNetworkID string, ip net.IP) {
 	n.Lock()
 	defer n.Unlock()
 
 	n.validNetworkIDs.Remove(validNetworkID)
+	if n.nodeNameserver != nil && ip != nil {
+		n.nodeNameserver.UnregisterSourceIPs([]net.IP{ip}, n)
+	}
+}
+
+func (n *nameserver) SetDNSConfig(dnsConfig DNSConfig) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.dnsConfig = dnsConfig
+	n.dnsOptions = dnsConfig.parseOptions()
+}
+
+func (n *nameserver) getDNSConfig() (DNSConfig, dnsOptions) {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.dnsConfig, n.dnsOptions
 }
 
 // ServeDNS handles DNS queries
 func (n *nameserver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	msg := dns.Msg{}
+	msg := &dns.Msg{}
 	msg.SetReply(r)
 	msg.Authoritative = false
 	msg.RecursionAvailable = true
 
-	// Iterate through all questions (usually one)
-	for _, q := range r.Question {
-		if q.Qtype == dns.TypeA {
-			// TODO: This results in a parse error on the DNS client side if more than a single result
-			//   is being returned. Can be tested by creating a service with a fixed hostname
-			msg.Answer = append(msg.Answer, n.resolver.ResolveName(q.Name, n.validNetworkIDs.Keys())...)
-		} else if q.Qtype == dns.TypePTR {
-			msg.Answer = append(msg.Answer, n.resolver.ResolveIP(q.Name, n.validNetworkIDs.Keys())...)
-		}
-
-		if len(msg.Answer) == 0 {
-			// TODO: Use DNS servers specified in daemon and container
-			c := new(dns.Client)
-			c.Net = "udp"
-			in, _, err := c.Exchange(r, "8.8.4.4:53")
-			if err != nil {
-				c.Net = "tcp"
-				in, _, err = c.Exchange(r, "8.8.4.4:53")
-				if err != nil {
-					log.Printf("Failed to forward DNS query from namespace %s: %v", n.networkNamespace, err)
-					msg.SetRcode(r, dns.RcodeServerFailure)
-					break
-				}
-			}
-			msg.Answer = append(msg.Answer, in.Answer...)
-			msg.Ns = append(msg.Ns, in.Ns...)
-			msg.Extra = append(msg.Extra, in.Extra...)
+	if len(r.Question) != 1 {
+		// No common DNS server supports multiple questions in one request, see RFC 9619
+		msg.SetRcode(r, dns.RcodeFormatError)
+		n.writeResponse(w, r, msg)
+		return
+	}
+
+	q := r.Question[0]
+	dnsConfig, dnsOptions := n.getDNSConfig()
+
+	rcode := dns.RcodeSuccess
+	answers, extra, ns := n.resolveInternally(q, dnsConfig)
+	if target, isUnresolved := getUnresolvedCNAMETarget(answers); isUnresolved && len(ns) == 0 && !dnsConfig.Policy.IsAllowed(target) {
+		// The CNAME record itself is internal, but the policy applies to its target
+		log.Printf("Denied DNS query for CNAME target %s %s from namespace %s", dns.TypeToString[q.Qtype], target, n.networkNamespace)
+		rcode = dnsConfig.Policy.GetDenyRcode()
+	} else if isUnresolved && len(ns) == 0 {
+		// Custom CNAME records may point to names that only the upstream servers know
+		targetRequest := r.Copy()
+		targetRequest.Question = []dns.Question{{Name: target, Qtype: q.Qtype, Qclass: q.Qclass}}
+		in, err := n.forwarder.Forward(targetRequest, getForwardOptions(dnsConfig, dnsOptions))
+		if err != nil {
+			log.Printf("Failed to forward DNS query for CNAME target %s from namespace %s: %v", target, n.networkNamespace, err)
 		} else {
-			// We are the authoritative DNS server for this request so it's
-			// on us to truncate the response message to the size limit
-			// negotiated by the client.
-			maxSize := dns.MinMsgSize
-			if w.LocalAddr().Network() == "tcp" {
-				maxSize = dns.MaxMsgSize
-			} else {
-				if optRR := r.IsEdns0(); optRR != nil {
-					if udpsize := int(optRR.UDPSize()); udpsize > maxSize {
-						maxSize = udpsize
-					}
+			rcode = in.Rcode
+			answers = append(answers, in.Answer...)
+			ns = in.Ns
+		}
+	}
+
+	if len(answers) > 0 || len(ns) > 0 {
+		msg.Rcode = rcode
+		msg.Answer = answers
+		msg.Ns = ns
+		msg.Extra = extra
+	} else if q.Qtype == dns.TypePTR && n.resolver.IsInternalPTR(q.Name) {
+		// Unknown IPs of our networks must not be leaked to the upstream servers
+		msg.SetRcode(r, dns.RcodeNameError)
+	} else if !dnsConfig.Policy.IsAllowed(q.Name) {
+		log.Printf("Denied DNS query for %s %s from namespace %s", dns.TypeToString[q.Qtype], q.Name, n.networkNamespace)
+		msg.SetRcode(r, dnsConfig.Policy.GetDenyRcode())
+	} else {
+		in, err := n.forward(r, q, dnsConfig, dnsOptions)
+		if err != nil {
+			log.Printf("Failed to forward DNS query from namespace %s: %v", n.networkNamespace, err)
+			msg.SetRcode(r, dns.RcodeServerFailure)
+		} else {
+			msg.Rcode = in.Rcode
+			msg.Answer = in.Answer
+			msg.Ns = in.Ns
+			msg.Extra = in.Extra
+		}
+	}
+
+	n.writeResponse(w, r, msg)
+}
+
+func (n *nameserver) writeResponse(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) {
+	if err := writeResponse(w, r, msg); err != nil {
+		log.Printf("Failed to write DNS response in namespace %s: %v", n.networkNamespace, err)
+	}
+}
+
+// writeResponse adds an OPT record if the request has one and truncates the response to the size the client
+// is able to receive. Truncated responses have the TC bit set, so the client retries over TCP
+func writeResponse(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) error {
+	// OPT records are hop-by-hop, so the ones of upstream responses must not be passed on
+	msg.Extra = lo.Filter(msg.Extra, func(record dns.RR, _ int) bool {
+		return record.Header().Rrtype != dns.TypeOPT
+	})
+
+	maxSize := dns.MinMsgSize
+	if optRR := r.IsEdns0(); optRR != nil {
+		msg.SetEdns0(dns.DefaultMsgSize, optRR.Do())
+		// The size the client advertises is the maximum size it can reassemble. Sizes below 512 mean 512
+		maxSize = min(max(int(optRR.UDPSize()), dns.MinMsgSize), dns.DefaultMsgSize)
+	}
+	if w.LocalAddr().Network() == "tcp" {
+		maxSize = dns.MaxMsgSize
+	}
+	msg.Truncate(maxSize)
+
+	return w.WriteMsg(msg)
+}
+
+// resolveInternally resolves the question using our resolver. If the name ends with one of the search domains
+// or the domainname of the container, the name without that domain is tried, too.
+// Internal names without records of the requested type result in NODATA, i.e. no answers and an SOA
+// record in ns. Both answers and ns are empty if the name is unknown and should be forwarded
+func (n *nameserver) resolveInternally(q dns.Question, dnsConfig DNSConfig) (answers, extra, ns []dns.RR) {
+	validNetworkIDs := n.validNetworkIDs.Keys()
+
+	if q.Qtype == dns.TypePTR {
+		return n.resolver.ResolveIP(q.Name, validNetworkIDs), nil, nil
+	}
+
+	for _, name := range searchCandidates(q.Name, dnsConfig.searchDomains()) {
+		switch q.Qtype {
+		case dns.TypeA:
+			answers = n.resolver.ResolveName(name, validNetworkIDs)
+		case dns.TypeSRV:
+			answers, extra = n.resolver.ResolveSRV(name, validNetworkIDs)
+		}
+		if len(answers) == 0 && q.Qtype != dns.TypeA {
+			answers, ns = n.resolveNoData(name, validNetworkIDs)
+		}
+
+		if len(answers) > 0 || len(ns) > 0 {
+			// The client expects the answers for the name it asked for. Records of CNAME targets keep their name
+			for _, record := range append(answers, ns...) {
+				if strings.EqualFold(record.Header().Name, name) {
+					record.Header().Name = q.Name
 				}
 			}
-			msg.Truncate(maxSize)
+			return answers, extra, ns
 		}
 	}
 
-	err := w.WriteMsg(&msg)
-	if err != nil {
-		log.Printf("Failed to write DNS response in namespace %s: %v", n.networkNamespace, err)
+	return nil, nil, nil
+}
+
+// resolveNoData handles queries for internal names with types we have no records for, e.g. AAAA queries for
+// services. They must not be forwarded. Instead, the answer is NODATA with an SOA record, so the client knows
+// that the name exists. CNAMEs of custom records are returned as is, so the upstream servers can resolve
+// targets outside the swarm
+func (n *nameserver) resolveNoData(name string, validNetworkIDs []string) (answers, ns []dns.RR) {
+	records := n.resolver.ResolveName(name, validNetworkIDs)
+	cnames := lo.Filter(records, func(record dns.RR, _ int) bool { return record.Header().Rrtype == dns.TypeCNAME })
+	aRecords := lo.Filter(records, func(record dns.RR, _ int) bool { return record.Header().Rrtype == dns.TypeA })
+	if len(aRecords) == 0 {
+		return cnames, nil
+	}
+
+	return cnames, []dns.RR{newSOA(internalZone, aRecords[0].Header().Ttl)}
+}
+
+// forward forwards the request to the upstream servers. Names with less dots than configured via the ndots
+// option are first tried with the search domains appended. If that succeeds, the answer contains a CNAME
+// from the requested name to the name with the search domain
+func (n *nameserver) forward(r *dns.Msg, q dns.Question, dnsConfig DNSConfig, dnsOptions dnsOptions) (*dns.Msg, error) {
+	forwardOptions := getForwardOptions(dnsConfig, dnsOptions)
+
+	if dns.CountLabel(q.Name)-1 < dnsOptions.ndots {
+		for _, domain := range dnsConfig.Search {
+			expandedName := dns.Fqdn(strings.TrimSuffix(q.Name, ".") + "." + strings.Trim(domain, "."))
+			if !dnsConfig.Policy.IsAllowed(expandedName) {
+				continue
+			}
+			expandedRequest := r.Copy()
+			expandedRequest.Question = []dns.Question{{Name: expandedName, Qtype: q.Qtype, Qclass: q.Qclass}}
+			in, err := n.forwarder.Forward(expandedRequest, forwardOptions)
+			if err != nil || in.Rcode != dns.RcodeSuccess || len(in.Answer) == 0 {
+				continue
+			}
+
+			in.Answer = append([]dns.RR{&dns.CNAME{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeCNAME,
+					Class:  dns.ClassINET,
+					Ttl:    in.Answer[0].Header().Ttl,
+				},
+				Target: expandedName,
+			}}, in.Answer...)
+			return in, nil
+		}
+	}
+
+	return n.forwarder.Forward(r, forwardOptions)
+}
+
+func getForwardOptions(dnsConfig DNSConfig, dnsOptions dnsOptions) ForwardOptions {
+	return ForwardOptions{
+		Servers:  dnsConfig.Servers,
+		Timeout:  dnsOptions.timeout,
+		Attempts: dnsOptions.attempts,
+		Rotate:   dnsOptions.rotate,
 	}
 }
 
+// getUnresolvedCNAMETarget returns the target of the last record of the answers if it is a CNAME,
+// i.e. if the target couldn't be resolved internally
+func getUnresolvedCNAMETarget(answers []dns.RR) (string, bool) {
+	if len(answers) == 0 {
+		return "", false
+	}
+	cname, isCNAME := answers[len(answers)-1].(*dns.CNAME)
+	if !isCNAME {
+		return "", false
+	}
+
+	return cname.Target, true
+}
+
 // startDnsServersInNamespace sets up DNS servers within the specified network namespace
 func (n *nameserver) startDnsServersInNamespace(ctx context.Context, dockerData docker.Data) error {
 	runtime.LockOSThread()
@@ -226,18 +423,82 @@ func (n *nameserver) startDnsServersInNamespace(ctx context.Context, dockerData
 
 	fmt.Printf("Both servers for %s have been started\n", n.networkNamespace)
 
-	if err = n.replaceDNATSNATRules(ctx); err != nil {
-		return errors.WithMessagef(err, "Failed to replace DNAT SNAT rules in namespace %s", n.networkNamespace)
+	redirect := dnsRedirect{ip: net.ParseIP(n.config.ListenIP), portTCP: portTCP, portUDP: portUDP}
+	if err = n.intercept(ctx, redirect); err != nil {
+		return errors.WithMessagef(err, "Failed to intercept DNS traffic in namespace %s", n.networkNamespace)
+	}
+
+	if err := n.setAllNetworksAsValid(dockerData); err != nil {
+		return errors.WithMessagef(err, "Error setting all networks as valid of container with namespace %s", n.networkNamespace)
+	}
+
+	fmt.Printf("Namespace %s DNS servers listening on TCP: %s:%d, UDP: %s:%d\n", n.networkNamespace, n.config.ListenIP, portTCP, n.config.ListenIP, portUDP)
+	return nil
+}
+
+// registerWithNodeNameserver redirects the DNS traffic of the namespace to the node-wide DNS server and
+// registers the addresses of the namespace with it, so it hands the queries of the container to us
+func (n *nameserver) registerWithNodeNameserver(ctx context.Context, dockerData docker.Data) error {
+	runtime.LockOSThread()
+
+	if err := n.setNamespace(ctx); err != nil {
+		return errors.WithMessagef(err, "Error setting namespace to %s", n.networkNamespace)
 	}
 
 	if err := n.setAllNetworksAsValid(dockerData); err != nil {
 		return errors.WithMessagef(err, "Error setting all networks as valid of container with namespace %s", n.networkNamespace)
 	}
 
-	fmt.Printf("Namespace %s DNS servers listening on TCP: 127.0.0.33:%d, UDP: 127.0.0.33:%d\n", n.networkNamespace, portTCP, portUDP)
+	sourceIPs, err := getNamespaceIPs()
+	if err != nil {
+		return errors.WithMessagef(err, "Error getting the addresses of namespace %s", n.networkNamespace)
+	}
+
+	ip, port, exists := n.nodeNameserver.GetListenAddress(n.validNetworkIDs.Keys())
+	if !exists {
+		return fmt.Errorf("none of the networks of namespace %s has a local gateway to reach the node DNS server", n.networkNamespace)
+	}
+
+	// The queries to 127.0.0.11 have a loopback source address. Without route_localnet, the kernel drops them
+	// after they have been redirected to a non-loopback address
+	if err := os.WriteFile("/proc/sys/net/ipv4/conf/all/route_localnet", []byte("1"), 0644); err != nil {
+		return errors.WithMessagef(err, "Error enabling route_localnet in namespace %s", n.networkNamespace)
+	}
+
+	if err = n.intercept(ctx, dnsRedirect{ip: ip, portTCP: port, portUDP: port, masquerade: true}); err != nil {
+		return errors.WithMessagef(err, "Failed to intercept DNS traffic in namespace %s", n.networkNamespace)
+	}
+
+	n.nodeNameserver.Register(sourceIPs, n)
+
+	fmt.Printf("Namespace %s uses node DNS server on %s:%d with source addresses %v\n", n.networkNamespace, ip, port, sourceIPs)
 	return nil
 }
 
+// getNamespaceIPs returns the IPv4 addresses of all non-loopback interfaces in the current namespace
+func getNamespaceIPs() ([]net.IP, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, errors.WithMessage(err, "error listing network interfaces")
+	}
+
+	result := []net.IP{}
+	for _, link := range links {
+		if link.Attrs().Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "error listing addresses of interface %s", link.Attrs().Name)
+		}
+		for _, addr := range addrs {
+			result = append(result, addr.IP)
+		}
+	}
+
+	return result, nil
+}
+
 func (n *nameserver) setAllNetworksAsValid(dockerData docker.Data) error {
 	links, err := netlink.LinkList()
 	if err != nil {
@@ -267,7 +528,7 @@ func (n *nameserver) setAllNetworksAsValid(dockerData docker.Data) error {
 			for _, addresses := range linkAddresses {
 				for _, address := range addresses.addrs {
 					if subnet.Contains(address.IP) {
-						n.AddValidNetworkID(network.DockerID)
+						n.AddValidNetworkID(network.DockerID, address.IP)
 					}
 				}
 			}
@@ -279,7 +540,7 @@ func (n *nameserver) setAllNetworksAsValid(dockerData docker.Data) error {
 // startDNSServer initializes and starts the DNS server for either TCP or UDP
 func (n *nameserver) startDNSServer(connType string, ctx context.Context) (int, error) {
 
-	listenAddr := fmt.Sprintf("%s:0", n.listenIP)
+	listenAddr := net.JoinHostPort(n.config.ListenIP, strconv.Itoa(n.config.ListenPort))
 
 	server := &dns.Server{Handler: n}
 	var port int
@@ -289,7 +550,7 @@ func (n *nameserver) startDNSServer(connType string, ctx context.Context) (int,
 		// Create UDP listener
 		udpConn, err := net.ListenPacket("udp", listenAddr)
 		if err != nil {
-			return 0, errors.WithMessagef(err, "Failed to create UDP listener on %s: %v", listenAddr)
+			return 0, errors.WithMessagef(err, "Failed to create UDP listener on %s", listenAddr)
 		}
 
 		// Retrieve the assigned port
@@ -312,7 +573,7 @@ func (n *nameserver) startDNSServer(connType string, ctx context.Context) (int,
 		// Create TCP listener
 		tcpListener, err := net.Listen("tcp", listenAddr)
 		if err != nil {
-			return 0, errors.WithMessagef(err, "Failed to create TCP listener on %s: %v", listenAddr)
+			return 0, errors.WithMessagef(err, "Failed to create TCP listener on %s", listenAddr)
 		}
 
 		// Retrieve the assigned port
@@ -345,8 +606,8 @@ func (n *nameserver) startDNSServer(connType string, ctx context.Context) (int,
 }
 
 // replaceDNATSNATRules replaces existing DNAT and SNAT iptables rules with new ones
-// that route DNS traffic to the specified ports of the DNS servers.
-func (n *nameserver) replaceDNATSNATRules(ctx context.Context) error {
+// that route DNS traffic to the DNS servers.
+func (n *nameserver) replaceDNATSNATRules(ctx context.Context, rules []networking.IptablesRule) error {
 	ipt, err := iptables.New()
 	if err != nil {
 		return errors.WithMessage(err, "Error initializing iptables")
@@ -369,67 +630,6 @@ func (n *nameserver) replaceDNATSNATRules(ctx context.Context) error {
 		}
 	}
 
-	rules := []networking.IptablesRule{
-		{
-			Chain: "FLANNEL_DNS_OUTPUT",
-			RuleSpec: []string{
-				"-d", "127.0.0.11/32",
-				"-p", "tcp",
-				"-m", "tcp",
-				"--dport", "53",
-				"-j", "DNAT",
-				"--to-destination", fmt.Sprintf("%s:%d", n.listenIP, n.portTCP),
-			},
-		},
-		{
-			Chain: "FLANNEL_DNS_OUTPUT",
-			RuleSpec: []string{
-				"-d", "127.0.0.11/32",
-				"-p", "udp",
-				"-m", "udp",
-				"--dport", "53",
-				"-j", "DNAT",
-				"--to-destination", fmt.Sprintf("%s:%d", n.listenIP, n.portUDP),
-			},
-		},
-		{
-			Chain: "FLANNEL_DNS_POSTROUTING",
-			RuleSpec: []string{
-				"-s", fmt.Sprintf("%s/32", n.listenIP),
-				"-p", "tcp",
-				"-m", "tcp",
-				"--sport", fmt.Sprintf("%d", n.portTCP),
-				"-j", "SNAT",
-				"--to-source", ":53",
-			},
-		},
-		{
-			Chain: "FLANNEL_DNS_POSTROUTING",
-			RuleSpec: []string{
-				"-s", fmt.Sprintf("%s/32", n.listenIP),
-				"-p", "udp",
-				"-m", "udp",
-				"--sport", fmt.Sprintf("%d", n.portUDP),
-				"-j", "SNAT",
-				"--to-source", ":53",
-			},
-		},
-		{
-			Chain: "OUTPUT",
-			RuleSpec: []string{
-				"-d", "127.0.0.11",
-				"-j", "FLANNEL_DNS_OUTPUT",
-			},
-		},
-		{
-			Chain: "POSTROUTING",
-			RuleSpec: []string{
-				"-d", "127.0.0.11",
-				"-j", "FLANNEL_DNS_POSTROUTING",
-			},
-		},
-	}
-
 	for _, rule := range rules {
 		fmt.Printf("Applying iptables rule %+v\n", rule)
 		if err := createChainIfNecessary(ipt, table, rule.Chain); err != nil {
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"net"
+	"testing"
+)
+
+// testForwarder answers all queries with an A record and records the forwarded names
+type testForwarder struct {
+	forwarded []string
+}
+
+func (f *testForwarder) Forward(request *dns.Msg, _ ForwardOptions) (*dns.Msg, error) {
+	q := request.Question[0]
+	f.forwarded = append(f.forwarded, q.Name)
+	response := &dns.Msg{}
+	response.SetReply(request)
+	if q.Qtype == dns.TypeA {
+		response.Answer = toARecords(q.Name, []net.IP{net.ParseIP("192.0.2.1")}, 60)
+	}
+	return response, nil
+}
+
+func newTestNameserver(dnsConfig DNSConfig) (*nameserver, *testForwarder) {
+	r, _ := newTestResolver()
+	forwarder := &testForwarder{}
+	validNetworkIDs := common.NewConcurrentMap[string, struct{}]()
+	validNetworkIDs.Set("n1", struct{}{})
+
+	return &nameserver{
+		networkNamespace: "test",
+		resolver:         r,
+		forwarder:        forwarder,
+		dnsConfig:        dnsConfig,
+		validNetworkIDs:  validNetworkIDs,
+	}, forwarder
+}
+
+func query(n *nameserver, name string, qtype uint16) *dns.Msg {
+	request := &dns.Msg{}
+	request.SetQuestion(name, qtype)
+	w := &testResponseWriter{network: "udp"}
+	n.ServeDNS(w, request)
+	return w.response
+}
+
+func TestWriteResponse(t *testing.T) {
+	manyAnswers := func() *dns.Msg {
+		msg := &dns.Msg{}
+		ips := []net.IP{}
+		for i := 1; i <= 60; i++ {
+			ips = append(ips, net.ParseIP(fmt.Sprintf("10.1.1.%d", i)))
+		}
+		msg.Answer = toARecords("app.", ips, 600)
+		msg.Extra = []dns.RR{&dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}}
+		return msg
+	}
+
+	tests := []struct {
+		name              string
+		network           string
+		ednsSize          uint16 // 0 means no EDNS0
+		expectedTruncated bool
+		expectedAnswers   int
+		expectedOPT       bool
+		maxSize           int
+	}{
+		{"UDP without EDNS0", "udp", 0, true, -1, false, dns.MinMsgSize},
+		{"UDP with EDNS0", "udp", 4096, false, 60, true, dns.DefaultMsgSize},
+		{"UDP with EDNS0 below the minimum size", "udp", 256, true, -1, true, dns.MinMsgSize},
+		{"UDP with EDNS0 above our maximum size", "udp", 65000, false, 60, true, dns.DefaultMsgSize},
+		{"TCP", "tcp", 0, false, 60, false, dns.MaxMsgSize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := &dns.Msg{}
+			request.SetQuestion("app.", dns.TypeA)
+			if test.ednsSize > 0 {
+				request.SetEdns0(test.ednsSize, false)
+			}
+			msg := manyAnswers()
+			msg.SetReply(request)
+			w := &testResponseWriter{network: test.network}
+
+			if err := writeResponse(w, request, msg); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if w.response.Truncated != test.expectedTruncated {
+				t.Errorf("expected truncated %v, got %v", test.expectedTruncated, w.response.Truncated)
+			}
+			if test.expectedAnswers >= 0 && len(w.response.Answer) != test.expectedAnswers {
+				t.Errorf("expected %d answers, got %d", test.expectedAnswers, len(w.response.Answer))
+			}
+			if (w.response.IsEdns0() != nil) != test.expectedOPT {
+				t.Errorf("expected OPT record %v, got %v", test.expectedOPT, w.response.Extra)
+			}
+			if optRR := w.response.IsEdns0(); optRR != nil && optRR.UDPSize() != dns.DefaultMsgSize {
+				t.Errorf("expected advertised size %d, got %d", dns.DefaultMsgSize, optRR.UDPSize())
+			}
+			opts := 0
+			for _, record := range w.response.Extra {
+				if record.Header().Rrtype == dns.TypeOPT {
+					opts++
+				}
+			}
+			if opts > 1 {
+				t.Errorf("expected at most one OPT record, got %d", opts)
+			}
+			if size := w.response.Len(); size > test.maxSize {
+				t.Errorf("expected at most %d bytes, got %d", test.maxSize, size)
+			}
+		})
+	}
+}
+
+func TestServeDNSInternalNames(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		qtype           uint16
+		expectedRcode   int
+		expectedAnswers int
+		expectedSOA     bool
+		forwarded       bool
+	}{
+		{"A record of a service", "app.", dns.TypeA, dns.RcodeSuccess, 1, false, false},
+		{"AAAA record of a service is NODATA", "app.", dns.TypeAAAA, dns.RcodeSuccess, 0, true, false},
+		{"AAAA record of a container is NODATA", "app.1.abc.", dns.TypeAAAA, dns.RcodeSuccess, 0, true, false},
+		{"external name", "example.com.", dns.TypeA, dns.RcodeSuccess, 1, false, true},
+		{"PTR of a container", "5.0.1.10.in-addr.arpa.", dns.TypePTR, dns.RcodeSuccess, 1, false, false},
+		{"PTR of an unknown internal IP", "9.0.1.10.in-addr.arpa.", dns.TypePTR, dns.RcodeNameError, 0, false, false},
+		{"PTR of an external IP", "1.2.0.192.in-addr.arpa.", dns.TypePTR, dns.RcodeSuccess, 0, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, forwarder := newTestNameserver(DNSConfig{Policy: NewQueryPolicy(nil)})
+			response := query(n, test.query, test.qtype)
+
+			if response.Rcode != test.expectedRcode {
+				t.Errorf("expected rcode %s, got %s", dns.RcodeToString[test.expectedRcode], dns.RcodeToString[response.Rcode])
+			}
+			if len(response.Answer) != test.expectedAnswers {
+				t.Errorf("expected %d answers, got %v", test.expectedAnswers, response.Answer)
+			}
+			for _, answer := range response.Answer {
+				if answer.Header().Name != test.query {
+					t.Errorf("expected answers for %s, got %s", test.query, answer.Header().Name)
+				}
+			}
+			if test.expectedSOA {
+				if len(response.Ns) != 1 || response.Ns[0].Header().Rrtype != dns.TypeSOA {
+					t.Fatalf("expected an SOA record, got %v", response.Ns)
+				}
+				if owner := response.Ns[0].Header().Name; owner != internalZone {
+					t.Errorf("expected the SOA record to be owned by %s, got %s", internalZone, owner)
+				}
+			}
+			if (len(forwarder.forwarded) > 0) != test.forwarded {
+				t.Errorf("expected forwarded %v, got %v", test.forwarded, forwarder.forwarded)
+			}
+		})
+	}
+}
@@ -3,12 +3,15 @@ package docker
 import (
 	"context"
 	"fmt"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"golang.org/x/sys/unix"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -86,6 +89,10 @@ func (d *data) getContainerInfoFromDocker(containerID string) (containerInfo *Co
 	serviceName := container.Config.Labels["com.docker.swarm.service.name"]
 	containerName := strings.TrimLeft(container.Name, "/")
 	sandboxKey := container.NetworkSettings.SandboxKey
+	health := ""
+	if container.State.Health != nil && container.State.Health.Status != types.NoHealthcheck {
+		health = container.State.Health.Status
+	}
 
 	ips := make(map[string]net.IP)
 	ipamIPs := make(map[string]net.IP)
@@ -102,8 +109,14 @@ func (d *data) getContainerInfoFromDocker(containerID string) (containerInfo *Co
 			IPs:         ips,
 			Endpoints:   endpoints,
 			DNSNames:    dnsNames,
+			Health:      health,
 		},
-		IpamIPs: ipamIPs,
+		IpamIPs:    ipamIPs,
+		DNSServers: container.HostConfig.DNS,
+		DNSSearch:  container.HostConfig.DNSSearch,
+		DNSOptions: container.HostConfig.DNSOptions,
+		Domainname: container.Config.Domainname,
+		Labels:     container.Config.Labels,
 	}
 
 	for networkName, networkData := range container.NetworkSettings.Networks {
@@ -129,9 +142,26 @@ func (d *data) getContainerInfoFromDocker(containerID string) (containerInfo *Co
 		endpoints[networkID] = networkData.EndpointID
 	}
 
+	containerInfo.Weight = getWeight(container.Config.Labels, d.nodeLabels)
+
+	// Docker doesn't know that the container is shutting down, so we keep what we know from the kill event
+	if _, existing, exists := d.containers.GetItem(containerID); exists {
+		containerInfo.Draining = existing.Draining
+	}
+
 	return
 }
 
+// getWeight returns the weight from the labels of the container or of its node, 0 if neither sets a valid weight.
+// The weight of the container takes precedence over the one of its node
+func getWeight(containerLabels, nodeLabels map[string]string) int {
+	if weight := common.ParseUint32Label(containerLabels, common.LBWeightLabel); weight > 0 {
+		return int(weight)
+	}
+
+	return int(common.ParseUint32Label(nodeLabels, common.LBWeightLabel))
+}
+
 func (d *data) handleContainer(containerID string) error {
 	containerInfo, err := d.getContainerInfoFromDocker(containerID)
 	if err != nil {
@@ -148,6 +178,53 @@ func (d *data) handleContainer(containerID string) error {
 	return nil
 }
 
+// handleKilledContainer marks a container that is being stopped as draining, so that the service load balancers
+// send no new connections to it. It is removed from the load balancers when it died. Docker stops a container
+// with its stop signal and kills it with SIGKILL after the stop timeout. Kill events report the signal as number
+func (d *data) handleKilledContainer(containerID, signal string) error {
+	_, container, exists := d.containers.GetItem(containerID)
+	if !exists || container.Draining {
+		return nil
+	}
+
+	if signal != strconv.Itoa(int(unix.SIGKILL)) {
+		rawContainer, err := d.dockerClient.ContainerInspect(context.Background(), containerID)
+		if err != nil {
+			return errors.WithMessagef(err, "Error inspecting docker container %s", containerID)
+		}
+		if signal != getStopSignal(rawContainer.Config.StopSignal) {
+			return nil
+		}
+	}
+
+	container.Draining = true
+	if err := d.containers.AddOrUpdateItem(containerID, container); err != nil {
+		return errors.WithMessagef(err, "Error marking container %s as draining", containerID)
+	}
+	return nil
+}
+
+// getStopSignal returns the number of the stop signal of a container, e.g. SIGUSR1, USR1 or 10. Docker uses
+// SIGTERM if the container has no stop signal
+func getStopSignal(stopSignal string) string {
+	stopSignal = strings.ToUpper(strings.TrimSpace(stopSignal))
+	if stopSignal == "" {
+		return strconv.Itoa(int(unix.SIGTERM))
+	}
+	if _, err := strconv.Atoi(stopSignal); err == nil {
+		return stopSignal
+	}
+	if !strings.HasPrefix(stopSignal, "SIG") {
+		stopSignal = "SIG" + stopSignal
+	}
+	if number := unix.SignalNum(stopSignal); number != 0 {
+		return strconv.Itoa(int(number))
+	}
+
+	log.Printf("Unknown stop signal %s, assuming SIGTERM\n", stopSignal)
+	return strconv.Itoa(int(unix.SIGTERM))
+}
+
 func (d *data) handleDeletedContainer(containerID string) error {
 	return d.containers.DeleteItem(containerID)
 }
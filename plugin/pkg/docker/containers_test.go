@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"testing"
+)
+
+func TestGetWeight(t *testing.T) {
+	tests := []struct {
+		name            string
+		containerLabels map[string]string
+		nodeLabels      map[string]string
+		expected        int
+	}{
+		{"no labels", nil, nil, 0},
+		{"container label", map[string]string{common.LBWeightLabel: "4"}, nil, 4},
+		{"node label", nil, map[string]string{common.LBWeightLabel: "2"}, 2},
+		{"container label takes precedence", map[string]string{common.LBWeightLabel: "4"}, map[string]string{common.LBWeightLabel: "2"}, 4},
+		{"zero container label falls back to the node", map[string]string{common.LBWeightLabel: "0"}, map[string]string{common.LBWeightLabel: "2"}, 2},
+		{"invalid container label falls back to the node", map[string]string{common.LBWeightLabel: "heavy"}, map[string]string{common.LBWeightLabel: "2"}, 2},
+		{"negative weight", map[string]string{common.LBWeightLabel: "-1"}, nil, 0},
+		{"empty value", map[string]string{common.LBWeightLabel: ""}, nil, 0},
+		{"other labels", map[string]string{"weight": "4"}, map[string]string{"flannel-np.weight": "2"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := getWeight(test.containerLabels, test.nodeLabels); actual != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetStopSignal(t *testing.T) {
+	tests := []struct {
+		stopSignal string
+		expected   string
+	}{
+		{"", "15"},
+		{"SIGTERM", "15"},
+		{"SIGUSR1", "10"},
+		{"usr1", "10"},
+		{" SIGWINCH ", "28"},
+		{"SIGHUP", "1"},
+		{"3", "3"},
+		{"SIGUNKNOWN", "15"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.stopSignal, func(t *testing.T) {
+			if actual := getStopSignal(test.stopSignal); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -27,7 +27,10 @@ type data struct {
 	containers        etcd.ShardedDistributedStore[ContainerInfo]
 	services          etcd.Store[ServiceInfo]
 	networks          etcd.Store[common.NetworkInfo]
+	nodes             etcd.Store[NodeInfo]
 	isManagerNode     bool
+	nodeID            string
+	nodeLabels        map[string]string // labels of the swarm node
 	containerHandlers etcd.ShardItemsHandlers[ContainerInfo]
 	serviceHandlers   etcd.ItemsHandlers[ServiceInfo]
 	networkHandlers   etcd.ItemsHandlers[common.NetworkInfo]
@@ -87,11 +90,28 @@ func NewData(etcdClient etcd.Client,
 		networks:          networks,
 		hostname:          hostname,
 		isManagerNode:     isManagerNode,
+		nodeID:            info.Swarm.NodeID,
+		nodeLabels:        map[string]string{},
 		containerHandlers: containerHandlers,
 		serviceHandlers:   serviceHandlers,
 		networkHandlers:   networkHandlers,
 	}
 
+	nodesEtcdClient := etcdClient.CreateSubClient("nodes")
+	if isManagerNode {
+		// Managers update their own labels when they handle the node events
+		result.nodes = etcd.NewWriteOnlyStore(nodesEtcdClient, etcd.ItemsHandlers[NodeInfo]{})
+	} else {
+		result.nodes = etcd.NewReadOnlyStore(nodesEtcdClient, etcd.ItemsHandlers[NodeInfo]{
+			OnAdded: func(added []etcd.Item[NodeInfo]) {
+				result.handleNodesChanged(lo.Map(added, func(item etcd.Item[NodeInfo], index int) NodeInfo { return item.Value }))
+			},
+			OnChanged: func(changes []etcd.ItemChange[NodeInfo]) {
+				result.handleNodesChanged(lo.Map(changes, func(item etcd.ItemChange[NodeInfo], index int) NodeInfo { return item.Current }))
+			},
+		})
+	}
+
 	return result, nil
 }
 
@@ -100,7 +120,12 @@ func (d *data) GetServices() etcd.Store[ServiceInfo]                       { ret
 func (d *data) GetNetworks() etcd.Store[common.NetworkInfo]                { return d.networks }
 
 func (d *data) Init() error {
-	err := d.initNetworks()
+	err := d.initNodes()
+	if err != nil {
+		return err
+	}
+
+	err = d.initNetworks()
 	if err != nil {
 		return err
 	}
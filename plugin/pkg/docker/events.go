@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/docker/docker/api/types/events"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -47,11 +48,25 @@ func (d *data) handleEvent(event events.Message) error {
 		//case events.ActionCreate:
 		//case events.ActionStart:
 		case events.ActionKill:
-		// TODO: Implement connection draining on service LB
+			return d.handleKilledContainer(event.Actor.ID, event.Actor.Attributes["signal"])
 		case events.ActionDie:
 			return d.handleDeletedContainer(event.Actor.ID)
 		case events.ActionDestroy:
 			return d.handleDeletedContainer(event.Actor.ID)
+		default:
+			// health_status events are followed by either the status or the output of the healthcheck
+			if strings.HasPrefix(string(event.Action), string(events.ActionHealthStatus)) {
+				return d.handleContainer(event.Actor.ID)
+			}
+		}
+	case events.NodeEventType:
+		switch event.Action {
+		case events.ActionCreate:
+			return d.handleNode(event.Actor.ID)
+		case events.ActionUpdate:
+			return d.handleNode(event.Actor.ID)
+		case events.ActionRemove:
+			return d.handleDeletedNode(event.Actor.ID)
 		}
 	case events.ServiceEventType:
 		switch event.Action {
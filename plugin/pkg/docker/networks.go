@@ -94,6 +94,9 @@ func (d *data) getNetworkInfoFromDocker(dockerNetworkID string) (networkInfo *co
 		FlannelID: flannelNetworkID,
 		Name:      network.Name,
 		Subnet:    subnet,
+		DNSTTL:    common.ParseUint32Label(network.Options, common.DNSTTLLabel),
+		DNSRRTTL:  common.ParseUint32Label(network.Options, common.DNSRRTTLLabel),
+		Ingress:   network.Ingress,
 	}, nil
 }
 
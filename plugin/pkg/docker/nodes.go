@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/etcd"
+	"log"
+)
+
+// Only managers can inspect the swarm nodes, so they publish the nodes in etcd and each node takes its own labels
+// from there
+
+func (d *data) initNodes() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.isManagerNode {
+		fmt.Println("Initializing nodes on manager node...")
+		nodesInfos, err := d.getNodesInfosFromDocker()
+		if err != nil {
+			return errors.WithMessage(err, "Error initializing nodes")
+		}
+
+		err = d.nodes.(etcd.WriteOnlyStore[NodeInfo]).Init(nodesInfos)
+		if err != nil {
+			return errors.WithMessage(err, "Error initializing nodes")
+		}
+	} else {
+		fmt.Println("Initializing nodes on worker node...")
+		err := d.nodes.(etcd.ReadOnlyStore[NodeInfo]).Init()
+		if err != nil {
+			return errors.WithMessage(err, "Error initializing nodes")
+		}
+	}
+
+	if node, exists := d.nodes.GetItem(d.nodeID); exists {
+		d.nodeLabels = node.Labels
+	}
+
+	fmt.Println("Nodes initialized")
+	return nil
+}
+
+func (d *data) getNodesInfosFromDocker() (nodesInfos map[string]NodeInfo, err error) {
+	rawNodes, err := d.dockerClient.NodeList(context.Background(), types.NodeListOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "Error listing docker nodes")
+	}
+
+	nodesInfos = map[string]NodeInfo{}
+
+	for _, node := range rawNodes {
+		nodesInfos[node.ID] = NodeInfo{
+			ID:       node.ID,
+			Hostname: node.Description.Hostname,
+			Labels:   node.Spec.Labels,
+		}
+	}
+
+	return
+}
+
+func (d *data) handleNode(nodeID string) error {
+	node, _, err := d.dockerClient.NodeInspectWithRaw(context.Background(), nodeID)
+	if err != nil {
+		return errors.WithMessagef(err, "Error inspecting docker node %s", nodeID)
+	}
+
+	nodeInfo := NodeInfo{
+		ID:       node.ID,
+		Hostname: node.Description.Hostname,
+		Labels:   node.Spec.Labels,
+	}
+
+	err = d.nodes.(etcd.WriteOnlyStore[NodeInfo]).AddOrUpdateItem(nodeID, nodeInfo)
+	if err != nil {
+		return errors.WithMessagef(err, "Error adding or updating node info %s", nodeID)
+	}
+
+	if nodeID == d.nodeID {
+		return d.setNodeLabelsLocked(nodeInfo.Labels)
+	}
+
+	return nil
+}
+
+func (d *data) handleDeletedNode(nodeID string) error {
+	fmt.Printf("Deleting node %s\n", nodeID)
+	return d.nodes.(etcd.WriteOnlyStore[NodeInfo]).DeleteItem(nodeID)
+}
+
+// handleNodesChanged updates the labels of this node on worker nodes, when a manager published them
+func (d *data) handleNodesChanged(nodes []NodeInfo) {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, node := range nodes {
+		if node.ID != d.nodeID {
+			continue
+		}
+		if err := d.setNodeLabelsLocked(node.Labels); err != nil {
+			log.Printf("Error updating the labels of node %s: %v\n", node.ID, err)
+		}
+	}
+}
+
+// setNodeLabelsLocked updates the weights of the local containers, because they default to the weight of the
+// node. Expects the lock to be held
+func (d *data) setNodeLabelsLocked(labels map[string]string) error {
+	if common.CompareStringMaps(d.nodeLabels, labels) {
+		return nil
+	}
+
+	d.nodeLabels = labels
+	for containerID := range d.containers.GetAll()[d.containers.GetLocalShardKey()] {
+		if err := d.handleContainer(containerID); err != nil {
+			return errors.WithMessagef(err, "Error updating container %s after the labels of node %s changed", containerID, d.nodeID)
+		}
+	}
+
+	return nil
+}
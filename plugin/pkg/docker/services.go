@@ -96,12 +96,31 @@ func (d *data) getServiceInfoFromDocker(serviceID string) (serviceInfo *ServiceI
 		service.Spec.TaskTemplate.Networks,
 		func(item swarm.NetworkAttachmentConfig, index int) string { return item.Target })
 
+	// The endpoint contains the actual state, e.g. the auto-assigned published ports, and is also set for
+	// services without an endpoint spec
+	ports := lo.Map(service.Endpoint.Ports, func(item swarm.PortConfig, index int) common.ServicePort {
+		return common.ServicePort{
+			Name:          item.Name,
+			Protocol:      string(item.Protocol),
+			TargetPort:    item.TargetPort,
+			PublishedPort: item.PublishedPort,
+			PublishMode:   string(item.PublishMode),
+		}
+	})
+
+	endpointMode := string(service.Endpoint.Spec.Mode)
+	if endpointMode == "" {
+		endpointMode = common.ServiceEndpointModeVip
+	}
+
 	serviceInfo = &ServiceInfo{
 		ID:           serviceID,
 		Name:         service.Spec.Name,
-		EndpointMode: string(service.Spec.EndpointSpec.Mode),
+		EndpointMode: endpointMode,
 		Networks:     networks,
 		IpamVIPs:     ipamVIPs,
+		Ports:        ports,
+		Labels:       service.Spec.Labels,
 	}
 
 	for _, endpoint := range service.Endpoint.VirtualIPs {
@@ -3,19 +3,33 @@ package docker
 import (
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
 	"net"
+	"slices"
 )
 
+type NodeInfo struct {
+	ID       string            `json:"NodeID"`
+	Hostname string            `json:"Hostname"`
+	Labels   map[string]string `json:"Labels"`
+}
+
 type ContainerInfo struct {
 	common.ContainerInfo
-	IpamIPs map[string]net.IP `json:"IpamIPs"` // networkID -> IP
+	IpamIPs    map[string]net.IP `json:"IpamIPs"`    // networkID -> IP
+	DNSServers []string          `json:"DNSServers"` // DNS servers specified via --dns
+	DNSSearch  []string          `json:"DNSSearch"`  // DNS search domains specified via --dns-search
+	DNSOptions []string          `json:"DNSOptions"` // DNS options specified via --dns-option
+	Domainname string            `json:"Domainname"`
+	Labels     map[string]string `json:"Labels"`
 }
 
 type ServiceInfo struct {
-	ID           string            `json:"ServiceID"`
-	Name         string            `json:"ServiceName"`
-	EndpointMode string            `json:"EndpointMode"` // dnsrr or vip
-	Networks     []string          `json:"Networks"`     // networkID
-	IpamVIPs     map[string]net.IP `json:"IpamVIPs"`     // networkID -> VIP
+	ID           string               `json:"ServiceID"`
+	Name         string               `json:"ServiceName"`
+	EndpointMode string               `json:"EndpointMode"` // dnsrr or vip
+	Networks     []string             `json:"Networks"`     // networkID
+	IpamVIPs     map[string]net.IP    `json:"IpamVIPs"`     // networkID -> VIP
+	Ports        []common.ServicePort `json:"Ports"`
+	Labels       map[string]string    `json:"Labels"`
 }
 
 func (c ContainerInfo) Equals(other common.Equaler) bool {
@@ -23,7 +37,7 @@ func (c ContainerInfo) Equals(other common.Equaler) bool {
 	if !ok {
 		return false
 	}
-	if c.ID != o.ID || c.Name != o.Name || c.ServiceID != o.ServiceID || c.ServiceName != o.ServiceName || c.SandboxKey != o.SandboxKey {
+	if c.ID != o.ID || c.Name != o.Name || c.ServiceID != o.ServiceID || c.ServiceName != o.ServiceName || c.SandboxKey != o.SandboxKey || c.Health != o.Health || c.Draining != o.Draining || c.Weight != o.Weight {
 		return false
 	}
 	if !common.CompareIPMaps(c.IPs, o.IPs) {
@@ -38,10 +52,28 @@ func (c ContainerInfo) Equals(other common.Equaler) bool {
 	if !common.CompareStringArrayMaps(c.DNSNames, o.DNSNames) {
 		return false
 	}
+	if !common.CompareStringSlices(c.DNSServers, o.DNSServers) ||
+		!common.CompareStringSlices(c.DNSSearch, o.DNSSearch) ||
+		!common.CompareStringSlices(c.DNSOptions, o.DNSOptions) ||
+		c.Domainname != o.Domainname {
+		return false
+	}
+	if !common.CompareStringMaps(c.Labels, o.Labels) {
+		return false
+	}
 
 	return true
 }
 
+func (n NodeInfo) Equals(other common.Equaler) bool {
+	o, ok := other.(NodeInfo)
+	if !ok {
+		return false
+	}
+
+	return n.ID == o.ID && n.Hostname == o.Hostname && common.CompareStringMaps(n.Labels, o.Labels)
+}
+
 func (c ServiceInfo) Equals(other common.Equaler) bool {
 	o, ok := other.(ServiceInfo)
 	if !ok {
@@ -53,6 +85,12 @@ func (c ServiceInfo) Equals(other common.Equaler) bool {
 	if !common.CompareIPMaps(c.IpamVIPs, o.IpamVIPs) {
 		return false
 	}
+	if !slices.Equal(c.Ports, o.Ports) {
+		return false
+	}
+	if !common.CompareStringMaps(c.Labels, o.Labels) {
+		return false
+	}
 
 	return true
 }
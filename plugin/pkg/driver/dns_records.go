@@ -0,0 +1,18 @@
+package driver
+
+import (
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/api"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/dns"
+)
+
+func (d *flannelDriver) GetDNSRecords() (*api.GetDNSRecordsResponse, error) {
+	return &api.GetDNSRecordsResponse{Records: d.dnsCustomRecords.GetAll()}, nil
+}
+
+func (d *flannelDriver) SetDNSRecord(request *dns.CustomRecord) error {
+	return d.dnsCustomRecords.SetRecord(*request)
+}
+
+func (d *flannelDriver) DeleteDNSRecord(request *api.DeleteDNSRecordRequest) error {
+	return d.dnsCustomRecords.DeleteRecord(request.Name, request.Network)
+}
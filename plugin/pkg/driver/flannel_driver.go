@@ -7,6 +7,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/api"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/bridge"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/dns"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/docker"
@@ -33,6 +34,18 @@ type etcdClients struct {
 	serviceLbs   etcd.Client
 	addressSpace etcd.Client
 	networks     etcd.Client
+	dnsRecords   etcd.Client
+}
+
+// Config holds the options of the DNS servers and the service load balancers
+type Config struct {
+	DNSTTL            dns.TTLConfig
+	DNSUpstream       dns.UpstreamConfig
+	DNSCache          dns.CacheConfig
+	DNSNameserver     dns.NameserverConfig
+	DNSHostNameserver dns.HostNameserverConfig
+	DNSNodeNameserver dns.NodeNameserverConfig
+	ServiceLbs        service_lb.ServiceLbsConfig
 }
 
 type networkKey struct {
@@ -46,6 +59,7 @@ type flannelDriver struct {
 	defaultHostSubnetSize   int
 	networks                *common.ConcurrentDualKeyMap[networkKey, string, string, flannel_network.Network]
 	serviceLbsManagement    service_lb.ServiceLbsManagement
+	serviceLbsConfig        service_lb.ServiceLbsConfig
 	services                *common.ConcurrentMap[string, common.Service] // service ID -> service
 	dockerData              docker.Data
 	completeAddressSpace    []net.IPNet
@@ -55,6 +69,12 @@ type flannelDriver struct {
 	nameserversBySandboxKey *common.ConcurrentMap[string, dns.Nameserver]
 	nameserversByEndpointID *common.ConcurrentMap[string, dns.Nameserver]
 	dnsResolver             dns.Resolver
+	dnsForwarder            dns.Forwarder
+	dnsCustomRecords        dns.CustomRecords
+	hostNameserver          dns.HostNameserver
+	nodeNameserver          dns.NodeNameserver
+	dnsNameserverConfig     dns.NameserverConfig
+	daemonDNSConfig         dns.DNSConfig
 	etcdClients             etcdClients
 	isHookAvailable         bool
 	sync.Mutex
@@ -63,7 +83,7 @@ type flannelDriver struct {
 func NewFlannelDriver(
 	etcdEndPoints []string, etcdPrefix string, defaultFlannelOptions []string, completeSpace []net.IPNet,
 	networkSubnetSize int, defaultHostSubnetSize int, vniStart int, dnsDockerCompatibilityMode bool,
-	isHookAvailable bool) FlannelDriver {
+	isHookAvailable bool, config Config) FlannelDriver {
 
 	driver := &flannelDriver{
 		defaultFlannelOptions:   defaultFlannelOptions,
@@ -77,15 +97,26 @@ func NewFlannelDriver(
 		networkSubnetSize:       networkSubnetSize,
 		nameserversBySandboxKey: common.NewConcurrentMap[string, dns.Nameserver](),
 		nameserversByEndpointID: common.NewConcurrentMap[string, dns.Nameserver](),
-		dnsResolver:             dns.NewResolver(dnsDockerCompatibilityMode),
+		dnsResolver:             dns.NewResolver(dnsDockerCompatibilityMode, config.DNSTTL),
+		dnsForwarder:            dns.NewCachingForwarder(dns.NewForwarder(config.DNSUpstream), config.DNSCache),
+		daemonDNSConfig:         dns.ReadDaemonDNSConfig(),
+		dnsNameserverConfig:     config.DNSNameserver,
+		serviceLbsConfig:        config.ServiceLbs,
 		etcdClients: etcdClients{
 			root:         getEtcdClient(etcdPrefix, "", etcdEndPoints),
 			dockerData:   getEtcdClient(etcdPrefix, "docker-data", etcdEndPoints),
 			serviceLbs:   getEtcdClient(etcdPrefix, "service-lbs", etcdEndPoints),
 			addressSpace: getEtcdClient(etcdPrefix, "address-space", etcdEndPoints),
 			networks:     getEtcdClient(etcdPrefix, "networks", etcdEndPoints),
+			dnsRecords:   getEtcdClient(etcdPrefix, "dns-records", etcdEndPoints),
 		},
 	}
+	if config.DNSHostNameserver.ListenAddress != "" {
+		driver.hostNameserver = dns.NewHostNameserver(config.DNSHostNameserver, driver.dnsResolver)
+	}
+	if config.DNSNodeNameserver.Enabled {
+		driver.nodeNameserver = dns.NewNodeNameserver(config.DNSNodeNameserver, driver.getLocalGateway)
+	}
 	if isHookAvailable {
 		if err := os.MkdirAll(dns.SandboxesPath, 0755); err != nil {
 			log.Fatalf("Error creating folder %s", dns.SandboxesPath)
@@ -110,6 +141,7 @@ func (d *flannelDriver) Serve() error {
 	handler := sdk.NewHandler(`{"Implements": ["IpamDriver", "NetworkDriver"]}`)
 	api.InitIpamMux(&handler, d)
 	api.InitNetworkMux(&handler, d)
+	api.InitDNSRecordsMux(&handler, d)
 
 	if err := handler.ServeUnix("flannel-np", 0); err != nil {
 		return errors.WithMessagef(err, "Failed to start flannel plugin server")
@@ -146,13 +178,26 @@ func (d *flannelDriver) Init() error {
 		OnRemoved: d.handleNetworksRemoved,
 	}
 
-	serviceLbsManagement, err := service_lb.NewServiceLbManagement(d.etcdClients.serviceLbs)
+	serviceLbsManagement, err := service_lb.NewServiceLbManagement(d.etcdClients.serviceLbs, d.serviceLbsConfig)
 	if err != nil {
 		return errors.WithMessage(err, "Failed to create service lbs management")
 	}
 	d.serviceLbsManagement = serviceLbsManagement
 	fmt.Println("Initialized service load balancer management")
 
+	d.dnsCustomRecords = dns.NewCustomRecords(d.etcdClients.dnsRecords, d.dnsResolver.SetCustomRecords)
+	if err := d.dnsCustomRecords.Init(); err != nil {
+		return errors.WithMessage(err, "Failed to initialize custom DNS records")
+	}
+	d.dnsResolver.SetCustomRecords(d.dnsCustomRecords.GetAll())
+	fmt.Println("Initialized custom DNS records")
+
+	if d.hostNameserver != nil {
+		if err := d.hostNameserver.Start(); err != nil {
+			return errors.WithMessage(err, "Failed to start host DNS server")
+		}
+	}
+
 	dockerDataInitialized := make(chan struct{})
 	go func() {
 		dockerData, err := docker.NewData(d.etcdClients.dockerData, containerCallbacks, serviceCallbacks, networkCallbacks)
@@ -240,6 +285,25 @@ func (d *flannelDriver) getEndpoint(dockerNetworkID, endpointID string) (flannel
 	return flannelNetwork, endpoint, nil
 }
 
+// getLocalGateway returns the bridge of the network on this node
+func (d *flannelDriver) getLocalGateway(dockerNetworkID string) (dns.LocalGateway, bool) {
+	flannelNetwork, exists, _ := d.networks.Get(networkKey{dockerID: dockerNetworkID})
+	if !exists {
+		return dns.LocalGateway{}, false
+	}
+
+	networkInfo := flannelNetwork.GetInfo()
+	if networkInfo.LocalGateway == nil {
+		return dns.LocalGateway{}, false
+	}
+
+	return dns.LocalGateway{
+		IP:            networkInfo.LocalGateway,
+		Subnet:        networkInfo.HostSubnet,
+		InterfaceName: bridge.GetBridgeInterfaceName(networkInfo.FlannelID),
+	}, true
+}
+
 func (d *flannelDriver) handleServicesAdded(added []etcd.Item[docker.ServiceInfo]) {
 	for _, addedItem := range added {
 		serviceInfo := addedItem.Value
@@ -250,8 +314,12 @@ func (d *flannelDriver) handleServicesAdded(added []etcd.Item[docker.ServiceInfo
 		// We set these two values in any case, even if the service already existed, because
 		// the service may have been added by its container (see handleContainersAdded) and
 		// in that case, this info wasn't set
+		service.SetPorts(serviceInfo.Ports)
+		service.SetLabels(serviceInfo.Labels)
 		service.SetEndpointMode(serviceInfo.EndpointMode)
 		service.SetNetworks(serviceInfo.Networks, serviceInfo.IpamVIPs)
+		// The containers may have been added before the service, i.e. without the labels of the service
+		d.updateDNSConfigOfServiceContainers(serviceInfo.ID)
 	}
 }
 
@@ -263,8 +331,13 @@ func (d *flannelDriver) handleServicesChanged(changed []etcd.ItemChange[docker.S
 			log.Printf("Received a change event for unknown service %s\n", serviceInfo.ID)
 			return d.createService(serviceInfo.ID, serviceInfo.Name), nil
 		})
+		service.SetPorts(serviceInfo.Ports)
+		service.SetLabels(serviceInfo.Labels)
 		service.SetEndpointMode(serviceInfo.EndpointMode)
 		service.SetNetworks(serviceInfo.Networks, serviceInfo.IpamVIPs)
+		if !common.CompareStringMaps(changedItem.Previous.Labels, serviceInfo.Labels) {
+			d.updateDNSConfigOfServiceContainers(serviceInfo.ID)
+		}
 	}
 }
 
@@ -323,6 +396,12 @@ func (d *flannelDriver) getOrCreateNetwork(dockerNetworkID string, flannelNetwor
 		if err != nil {
 			return nil, errors.WithMessagef(err, "Failed to add network '%s' to service load balancer management", flannelNetworkID)
 		}
+
+		if d.nodeNameserver != nil {
+			if err := d.nodeNameserver.AddNetwork(dockerNetworkID); err != nil {
+				return nil, errors.WithMessagef(err, "Failed to add network '%s' to the node DNS server", flannelNetworkID)
+			}
+		}
 	}
 
 	return network, nil
@@ -339,7 +418,7 @@ func (d *flannelDriver) handleNetworksAdded(added []etcd.Item[common.NetworkInfo
 				log.Printf("Failed to handle added or changed network %s / %s: %s\n", networkInfo.DockerID, networkInfo.FlannelID, err)
 			}
 		} else {
-			d.serviceLbsManagement.RegisterOtherNetwork(networkInfo.DockerID)
+			d.serviceLbsManagement.RegisterOtherNetwork(networkInfo)
 		}
 	}
 }
@@ -347,13 +426,14 @@ func (d *flannelDriver) handleNetworksAdded(added []etcd.Item[common.NetworkInfo
 func (d *flannelDriver) handleNetworksChanged(changed []etcd.ItemChange[common.NetworkInfo]) {
 	for _, changedItem := range changed {
 		networkInfo := changedItem.Current
+		d.dnsResolver.AddNetwork(networkInfo)
 		if networkInfo.IsFlannelNetwork() {
 			_, err := d.getOrCreateNetwork(networkInfo.DockerID, networkInfo.FlannelID)
 			if err != nil {
 				log.Printf("Failed to handle added or changed network %s / %s: %s\n", networkInfo.DockerID, networkInfo.FlannelID, err)
 			}
 		} else {
-			d.serviceLbsManagement.RegisterOtherNetwork(networkInfo.Name)
+			d.serviceLbsManagement.RegisterOtherNetwork(networkInfo)
 		}
 	}
 }
@@ -366,6 +446,11 @@ func (d *flannelDriver) handleNetworksRemoved(removed []etcd.Item[common.Network
 		if err := d.serviceLbsManagement.DeleteNetwork(networkInfo.DockerID); err != nil {
 			log.Printf("Error handling deleted network %s, err: %v", networkInfo.FlannelID, err)
 		}
+		if d.nodeNameserver != nil {
+			if err := d.nodeNameserver.RemoveNetwork(networkInfo.DockerID); err != nil {
+				log.Printf("Error removing network %s from the node DNS server, err: %v", networkInfo.FlannelID, err)
+			}
+		}
 		network, exists := d.getNetwork(networkInfo.DockerID, networkInfo.FlannelID)
 		if exists {
 			fmt.Printf("Deleting network %s\n", networkInfo.FlannelID)
@@ -386,8 +471,13 @@ func (d *flannelDriver) handleNetworksRemoved(removed []etcd.Item[common.Network
 func (d *flannelDriver) handleContainersAdded(added []etcd.ShardItem[docker.ContainerInfo]) {
 	for _, addedItem := range added {
 		containerInfo := addedItem.Value
+		containerInfo.Node = addedItem.ShardKey
 		fmt.Printf("Handling added container %s (%s)\n", containerInfo.Name, containerInfo.ID)
 		d.dnsResolver.AddContainer(containerInfo.ContainerInfo)
+		nameserver, exists := d.nameserversBySandboxKey.Get(containerInfo.SandboxKey)
+		if exists {
+			nameserver.SetDNSConfig(d.getDNSConfig(containerInfo))
+		}
 		for dockerNetworkID, ipamIP := range containerInfo.IpamIPs {
 			network, exists, _ := d.networks.Get(networkKey{dockerID: dockerNetworkID})
 			if !exists {
@@ -420,22 +510,30 @@ func (d *flannelDriver) handleContainersAdded(added []etcd.ShardItem[docker.Cont
 func (d *flannelDriver) handleContainersChanged(changed []etcd.ShardItemChange[docker.ContainerInfo]) {
 	for _, changedItem := range changed {
 		containerInfo := changedItem.Current
+		containerInfo.Node = changedItem.ShardKey
 		fmt.Printf("Handling changed container %s (%s)\n", containerInfo.Name, containerInfo.ID)
 		d.dnsResolver.UpdateContainer(containerInfo.ContainerInfo)
+		if containerInfo.ServiceID != "" {
+			service, exists := d.services.Get(containerInfo.ServiceID)
+			if exists {
+				service.UpdateContainer(containerInfo.ContainerInfo)
+			}
+		}
 		nameserver, exists := d.nameserversBySandboxKey.Get(containerInfo.SandboxKey)
 		if exists {
 			// Only handle changed networks if we already have a nameserver for this container, because
 			// we only care about containers that are connected to at least one of our networks and
 			// for such containers we create a nameserver in the call to Join
 
+			nameserver.SetDNSConfig(d.getDNSConfig(containerInfo))
 			removed, added := lo.Difference(maps.Keys(changedItem.Previous.Endpoints), maps.Keys(changedItem.Current.Endpoints))
 			for _, removedNetworkID := range removed {
 				d.nameserversByEndpointID.Remove(changedItem.Previous.Endpoints[removedNetworkID])
-				nameserver.RemoveValidNetworkID(removedNetworkID)
+				nameserver.RemoveValidNetworkID(removedNetworkID, changedItem.Previous.IPs[removedNetworkID])
 			}
 			for _, addedNetworkID := range added {
 				d.nameserversByEndpointID.Set(changedItem.Current.Endpoints[addedNetworkID], nameserver)
-				nameserver.AddValidNetworkID(addedNetworkID)
+				nameserver.AddValidNetworkID(addedNetworkID, changedItem.Current.IPs[addedNetworkID])
 			}
 		}
 	}
@@ -480,7 +578,7 @@ func countPoolSizeSubnets(completeSpace []net.IPNet, poolSize int) int {
 
 func (d *flannelDriver) getOrAddNameserver(sandboxKey string) (dns.Nameserver, <-chan error) {
 	nameserver, wasAdded, err := d.nameserversBySandboxKey.GetOrAdd(sandboxKey, func() (dns.Nameserver, error) {
-		return dns.NewNameserver(sandboxKey, d.dnsResolver, d.isHookAvailable)
+		return dns.NewNameserver(sandboxKey, d.dnsNameserverConfig, d.dnsResolver, d.dnsForwarder, d.nodeNameserver, d.isHookAvailable)
 	})
 	if err != nil {
 		errCh := make(chan error, 1)
@@ -497,6 +595,41 @@ func (d *flannelDriver) getOrAddNameserver(sandboxKey string) (dns.Nameserver, <
 	return nameserver, nameserver.Activate(d.dockerData)
 }
 
+func (d *flannelDriver) getDNSConfig(container docker.ContainerInfo) dns.DNSConfig {
+	// The labels of the container take precedence over the labels of its service
+	labels := map[string]string{}
+	if service, exists := d.services.Get(container.ServiceID); exists {
+		labels = lo.Assign(labels, service.GetInfo().Labels)
+	}
+	labels = lo.Assign(labels, container.Labels)
+
+	return dns.DNSConfig{
+		Servers:    container.DNSServers,
+		Search:     container.DNSSearch,
+		Options:    container.DNSOptions,
+		Domainname: container.Domainname,
+		Policy:     dns.NewQueryPolicy(labels),
+	}.WithDefaults(d.daemonDNSConfig)
+}
+
+// updateDNSConfigOfServiceContainers updates the DNS config of the containers of the service on this node,
+// e.g. after the labels of the service changed
+func (d *flannelDriver) updateDNSConfigOfServiceContainers(serviceID string) {
+	containersStore := d.dockerData.GetContainers()
+	localContainers, exists := containersStore.GetShard(containersStore.GetLocalShardKey())
+	if !exists {
+		return
+	}
+	for _, container := range localContainers {
+		if container.ServiceID != serviceID {
+			continue
+		}
+		if nameserver, exists := d.nameserversBySandboxKey.Get(container.SandboxKey); exists {
+			nameserver.SetDNSConfig(d.getDNSConfig(container))
+		}
+	}
+}
+
 func (d *flannelDriver) createService(id, name string) common.Service {
 	service := common.NewService(id, name)
 
@@ -532,15 +665,17 @@ func (d *flannelDriver) injectNameserverIntoAlreadyRunningContainers() {
 				go func() {
 					nameserver, errChan := d.getOrAddNameserver(container.SandboxKey)
 					if err := <-errChan; err != nil {
-						log.Printf("Error getting nameserver for container %s: %v\n", container, err)
+						log.Printf("Error getting nameserver for container %s: %v\n", container.ID, err)
 						return
 					}
 
-					fmt.Printf("Injecting nameserver for container %s. endpoints: %v\n", container, container.Endpoints)
+					fmt.Printf("Injecting nameserver for container %s. endpoints: %v\n", container.ID, container.Endpoints)
+
+					nameserver.SetDNSConfig(d.getDNSConfig(container))
 
 					for networkID, endpointID := range container.Endpoints {
 						d.nameserversByEndpointID.Set(endpointID, nameserver)
-						nameserver.AddValidNetworkID(networkID)
+						nameserver.AddValidNetworkID(networkID, container.IPs[networkID])
 					}
 				}()
 			}
@@ -119,7 +119,7 @@ func (d *flannelDriver) Join(request *network.JoinRequest) (*network.JoinRespons
 
 	start := time.Now()
 	nameserver, errChan := d.getOrAddNameserver(request.SandboxKey)
-	nameserver.AddValidNetworkID(request.NetworkID)
+	nameserver.AddValidNetworkID(request.NetworkID, endpointInfo.IpAddress)
 
 	go func() {
 		if err := <-errChan; err != nil {
@@ -157,6 +157,7 @@ func (d *flannelDriver) Leave(request *network.LeaveRequest) error {
 		return errors.WithMessagef(err, "failed to get endpoint %s", request.EndpointID)
 	}
 
+	endpointInfo := endpoint.GetInfo()
 	err = endpoint.Leave()
 	if err != nil {
 		return errors.WithMessagef(err, "failed to leave endpoint %s", request.EndpointID)
@@ -164,7 +165,7 @@ func (d *flannelDriver) Leave(request *network.LeaveRequest) error {
 
 	nameserver, wasRemoved := d.nameserversByEndpointID.TryRemove(request.EndpointID)
 	if wasRemoved {
-		nameserver.RemoveValidNetworkID(request.NetworkID)
+		nameserver.RemoveValidNetworkID(request.NetworkID, endpointInfo.IpAddress)
 	}
 
 	return nil
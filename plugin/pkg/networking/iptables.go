@@ -4,15 +4,24 @@ import (
 	"fmt"
 	"github.com/coreos/go-iptables/iptables"
 	"log"
+	"strings"
 )
 
+// IptablesRule is a rule in iptables syntax. It is the format of the rules of all backends
 type IptablesRule struct {
 	Table    string
 	Chain    string
 	RuleSpec []string
 }
 
+// ApplyIpTablesRules creates or deletes the rules with the configured rules backend
 func ApplyIpTablesRules(rules []IptablesRule, action string) error {
+	return rulesBackend.Apply(rules, action)
+}
+
+type iptablesBackend struct{}
+
+func (b *iptablesBackend) Apply(rules []IptablesRule, action string) error {
 	iptablev4, err := iptables.New()
 	if err != nil {
 		log.Printf("Error initializing iptables: %v", err)
@@ -41,3 +50,41 @@ func ApplyIpTablesRules(rules []IptablesRule, action string) error {
 
 	return nil
 }
+
+func (b *iptablesBackend) exists(rule IptablesRule) (bool, error) {
+	iptablev4, err := iptables.New()
+	if err != nil {
+		return false, err
+	}
+
+	return iptablev4.Exists(rule.Table, rule.Chain, rule.RuleSpec...)
+}
+
+func (b *iptablesBackend) List(table, chain string) ([]IptablesRule, error) {
+	iptablev4, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := iptablev4.ChainExists(table, chain)
+	if err != nil || !exists {
+		return []IptablesRule{}, err
+	}
+
+	rawRules, err := iptablev4.List(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []IptablesRule{}
+	for _, rawRule := range rawRules {
+		// -A <chain> <rule spec>
+		fields := strings.Fields(rawRule)
+		if len(fields) <= 2 || fields[0] != "-A" {
+			continue
+		}
+		rules = append(rules, IptablesRule{Table: table, Chain: chain, RuleSpec: fields[2:]})
+	}
+
+	return rules, nil
+}
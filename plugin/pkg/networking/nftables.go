@@ -0,0 +1,397 @@
+package networking
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	nftablesTable = "flannel_np"
+	// nftablesCommentMaxLength is the maximum length of the comment of a rule, see NFT_USERDATA_MAXLEN
+	nftablesCommentMaxLength = 128
+)
+
+type nftablesBaseChain struct {
+	chainType string
+	hook      string
+	priority  int
+}
+
+// nftablesBaseChains are the base chains of our table that replace the built-in chains of iptables. They have
+// the priorities of the iptables tables, see "man nft"
+var nftablesBaseChains = map[string]nftablesBaseChain{
+	"raw/PREROUTING":     {"filter", "prerouting", -300},
+	"raw/OUTPUT":         {"filter", "output", -300},
+	"mangle/PREROUTING":  {"filter", "prerouting", -150},
+	"mangle/INPUT":       {"filter", "input", -150},
+	"mangle/FORWARD":     {"filter", "forward", -150},
+	"mangle/OUTPUT":      {"route", "output", -150},
+	"mangle/POSTROUTING": {"filter", "postrouting", -150},
+	"nat/PREROUTING":     {"nat", "prerouting", -100},
+	"nat/INPUT":          {"nat", "input", 100},
+	"nat/OUTPUT":         {"nat", "output", -100},
+	"nat/POSTROUTING":    {"nat", "postrouting", 100},
+	"filter/INPUT":       {"filter", "input", 0},
+	"filter/FORWARD":     {"filter", "forward", 0},
+	"filter/OUTPUT":      {"filter", "output", 0},
+}
+
+// nftablesRuleLine matches a rule in the output of "nft -a list table". The comment is the rule in iptables syntax
+var nftablesRuleLine = regexp.MustCompile(`comment "(.*)" # handle (\d+)$`)
+
+// nftablesBackend programs the rules in our own nftables table. The rules in our table are changed in a single
+// nftables transaction per call of Apply. The rules are identified by their comment, which is the rule in iptables
+// syntax. The rules that have to be in the iptables chains of docker are programmed with iptables, see
+// requiresIptables. They are applied first and rolled back if the nftables transaction fails
+type nftablesBackend struct {
+	sync.Mutex
+	iptables *iptablesBackend
+}
+
+type nftablesRule struct {
+	comment string
+	handle  int
+}
+
+func newNftablesBackend() RulesBackend {
+	return &nftablesBackend{iptables: &iptablesBackend{}}
+}
+
+func (b *nftablesBackend) Apply(rules []IptablesRule, action string) error {
+	if action != "create" && action != "delete" {
+		return fmt.Errorf("invalid action. specify 'create' or 'delete'")
+	}
+
+	iptablesRules := []IptablesRule{}
+	nftablesRules := []IptablesRule{}
+	for _, rule := range rules {
+		if requiresIptables(rule) {
+			iptablesRules = append(iptablesRules, rule)
+		} else {
+			nftablesRules = append(nftablesRules, rule)
+		}
+	}
+
+	appliedIptablesRules, err := b.applyIptablesRules(iptablesRules, action)
+	if err == nil {
+		err = b.applyNftablesRules(nftablesRules, action)
+	}
+	if err != nil {
+		b.rollbackIptablesRules(appliedIptablesRules, action)
+		return err
+	}
+
+	return nil
+}
+
+// applyIptablesRules returns the rules that it changed, i.e. without the rules that already existed when creating
+func (b *nftablesBackend) applyIptablesRules(rules []IptablesRule, action string) ([]IptablesRule, error) {
+	applied := []IptablesRule{}
+	for _, rule := range rules {
+		exists, err := b.iptables.exists(rule)
+		if err != nil {
+			return applied, errors.WithMessagef(err, "Error checking iptables rule %s of table %s, chain %s", strings.Join(rule.RuleSpec, " "), rule.Table, rule.Chain)
+		}
+		if err := b.iptables.Apply([]IptablesRule{rule}, action); err != nil {
+			return applied, errors.WithMessage(err, "Error applying the rules that integrate with the iptables chains of docker")
+		}
+		if exists != (action == "create") {
+			applied = append(applied, rule)
+		}
+	}
+
+	return applied, nil
+}
+
+func (b *nftablesBackend) rollbackIptablesRules(rules []IptablesRule, action string) {
+	oppositeAction := "delete"
+	if action == "delete" {
+		oppositeAction = "create"
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := b.iptables.Apply([]IptablesRule{rules[i]}, oppositeAction); err != nil {
+			log.Printf("Error rolling back iptables rule %s of table %s, chain %s: %v", strings.Join(rules[i].RuleSpec, " "), rules[i].Table, rules[i].Chain, err)
+		}
+	}
+}
+
+func (b *nftablesBackend) applyNftablesRules(rules []IptablesRule, action string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	existingRules, err := listNftablesRules()
+	if err != nil {
+		return errors.WithMessagef(err, "Error listing the rules of nftables table %s", nftablesTable)
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "add table ip %s\n", nftablesTable)
+	for _, rule := range rules {
+		baseChain := nftablesBaseChains[rule.Table+"/"+rule.Chain]
+		chain := getNftablesChainName(rule)
+		comment := getNftablesComment(rule.RuleSpec)
+		matchingRules, otherRules := partitionNftablesRules(existingRules[chain], comment)
+
+		if action == "create" {
+			if len(matchingRules) > 0 {
+				continue
+			}
+			expression, err := toNftablesExpression(rule.RuleSpec)
+			if err != nil {
+				return errors.WithMessagef(err, "Error translating rule %s of table %s, chain %s", comment, rule.Table, rule.Chain)
+			}
+			fmt.Fprintf(&script, "add chain ip %s %s { type %s hook %s priority %d; policy accept; }\n",
+				nftablesTable, chain, baseChain.chainType, baseChain.hook, baseChain.priority)
+			fmt.Fprintf(&script, "add rule ip %s %s %s comment \"%s\"\n", nftablesTable, chain, expression, comment)
+			// Prevents duplicates if a rule is passed twice
+			existingRules[chain] = append(existingRules[chain], nftablesRule{comment: comment})
+		} else {
+			if len(matchingRules) == 0 {
+				return fmt.Errorf("rule %s doesn't exist in table %s, chain %s", comment, rule.Table, rule.Chain)
+			}
+			for _, matchingRule := range matchingRules {
+				fmt.Fprintf(&script, "delete rule ip %s %s handle %d\n", nftablesTable, chain, matchingRule.handle)
+			}
+			existingRules[chain] = otherRules
+		}
+	}
+
+	fmt.Printf("Applying (%s) nftables rules:\n%s", action, script.String())
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.WithMessagef(err, "Error applying nftables rules: %s", output)
+	}
+
+	return nil
+}
+
+// List returns the rules of the chain in our table and the rules of the chain in iptables that we would program
+// with iptables. Rules that are longer than a comment are returned as their truncated comment ending in
+// "#<hash>", see getNftablesComment. Such a rule spec can only be passed to Apply to delete the rule
+func (b *nftablesBackend) List(table, chain string) ([]IptablesRule, error) {
+	iptablesRules, err := b.iptables.List(table, chain)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Error listing the iptables rules of table %s, chain %s", table, chain)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	existingRules, err := listNftablesRules()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Error listing the rules of nftables table %s", nftablesTable)
+	}
+
+	rules := []IptablesRule{}
+	for _, rule := range iptablesRules {
+		if requiresIptables(rule) {
+			rules = append(rules, rule)
+		}
+	}
+	for _, rule := range existingRules[getNftablesChainName(IptablesRule{Table: table, Chain: chain})] {
+		rules = append(rules, IptablesRule{Table: table, Chain: chain, RuleSpec: strings.Fields(rule.comment)})
+	}
+
+	return rules, nil
+}
+
+func getNftablesChainName(rule IptablesRule) string {
+	return strings.ToLower(rule.Table + "_" + rule.Chain)
+}
+
+// requiresIptables returns true for the rules that have to be programmed with iptables: the rules in the chains
+// of docker, the jumps to these chains and the accepts in the filter table. A packet has to be accepted by the
+// base chains of all tables of a hook, so an accept in our table wouldn't override a drop in the iptables
+// chains of docker, e.g. the policy of FORWARD
+func requiresIptables(rule IptablesRule) bool {
+	if _, isBaseChain := nftablesBaseChains[rule.Table+"/"+rule.Chain]; !isBaseChain {
+		return true
+	}
+
+	target := ""
+	for i, field := range rule.RuleSpec {
+		if field == "-j" && i+1 < len(rule.RuleSpec) {
+			target = rule.RuleSpec[i+1]
+		}
+	}
+
+	return strings.HasPrefix(target, "DOCKER") || (rule.Table == "filter" && target == "ACCEPT")
+}
+
+// getNftablesComment returns the rule in iptables syntax. Rules that are longer than the maximum length of a
+// comment are truncated and get a hash of the whole rule, which keeps their comments unique
+func getNftablesComment(ruleSpec []string) string {
+	comment := strings.Join(ruleSpec, " ")
+	if len(comment) <= nftablesCommentMaxLength {
+		return comment
+	}
+
+	hash := sha256.Sum256([]byte(comment))
+	suffix := " #" + hex.EncodeToString(hash[:8])
+
+	return strings.TrimSpace(comment[:nftablesCommentMaxLength-len(suffix)]) + suffix
+}
+
+func partitionNftablesRules(rules []nftablesRule, comment string) (matching []nftablesRule, others []nftablesRule) {
+	for _, rule := range rules {
+		if rule.comment == comment {
+			matching = append(matching, rule)
+		} else {
+			others = append(others, rule)
+		}
+	}
+
+	return
+}
+
+// listNftablesRules returns the rules of our table. chain -> rules
+func listNftablesRules() (map[string][]nftablesRule, error) {
+	result := map[string][]nftablesRule{}
+
+	output, err := exec.Command("nft", "-a", "list", "table", "ip", nftablesTable).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "No such file or directory") {
+			// The table doesn't exist yet
+			return result, nil
+		}
+		return nil, errors.WithMessagef(err, "%s", output)
+	}
+
+	chain := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "chain" {
+			chain = fields[1]
+			continue
+		}
+		match := nftablesRuleLine.FindStringSubmatch(line)
+		if match == nil || chain == "" {
+			continue
+		}
+		handle, err := strconv.Atoi(match[2])
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid handle of rule %s", line)
+		}
+		result[chain] = append(result[chain], nftablesRule{comment: match[1], handle: handle})
+	}
+
+	return result, scanner.Err()
+}
+
+// toNftablesExpression translates the matches and targets of iptables that the plugin uses
+func toNftablesExpression(ruleSpec []string) (string, error) {
+	result := []string{}
+	protocol := ""
+	target := ""
+	targetOptions := map[string]string{}
+	negate := false
+
+	for i := 0; i < len(ruleSpec); i++ {
+		option := ruleSpec[i]
+		if option == "!" {
+			negate = true
+			continue
+		}
+		if i+1 >= len(ruleSpec) {
+			return "", fmt.Errorf("option %s has no value", option)
+		}
+		i++
+		value := ruleSpec[i]
+
+		operator := ""
+		if negate {
+			operator = "!= "
+		}
+		negate = false
+
+		switch option {
+		case "-s":
+			result = append(result, "ip saddr "+operator+value)
+		case "-d":
+			result = append(result, "ip daddr "+operator+value)
+		case "-i":
+			result = append(result, fmt.Sprintf("iifname %s\"%s\"", operator, value))
+		case "-o":
+			result = append(result, fmt.Sprintf("oifname %s\"%s\"", operator, value))
+		case "-p":
+			protocol = value
+			result = append(result, "meta l4proto "+operator+value)
+		case "-m":
+			// The matches are implied by their options
+			if operator != "" {
+				return "", fmt.Errorf("negated match %s is not supported", value)
+			}
+		case "--dport", "--sport":
+			if protocol == "" {
+				return "", fmt.Errorf("option %s without protocol", option)
+			}
+			result = append(result, fmt.Sprintf("%s %s %s%s", protocol, strings.TrimPrefix(option, "--"), operator, value))
+		case "--mark":
+			result = append(result, "meta mark "+operator+value)
+		case "--dst-type":
+			result = append(result, "fib daddr type "+operator+strings.ToLower(value))
+		case "--ctstate":
+			result = append(result, "ct state "+operator+strings.ToLower(value))
+		case "--ctorigdst":
+			result = append(result, "ct original ip daddr "+operator+value)
+		case "--icmp-type":
+			result = append(result, "icmp type "+operator+value)
+		case "-j":
+			target = value
+		case "--set-mark", "--reject-with", "--to-destination", "--to-source":
+			targetOptions[option] = value
+		default:
+			return "", fmt.Errorf("option %s is not supported", option)
+		}
+	}
+
+	switch target {
+	case "":
+	case "ACCEPT", "DROP", "RETURN":
+		result = append(result, strings.ToLower(target))
+	case "MASQUERADE":
+		result = append(result, "masquerade")
+	case "MARK":
+		mark, exists := targetOptions["--set-mark"]
+		if !exists {
+			return "", fmt.Errorf("target MARK without --set-mark")
+		}
+		result = append(result, "meta mark set "+mark)
+	case "REJECT":
+		switch targetOptions["--reject-with"] {
+		case "":
+			result = append(result, "reject")
+		case "tcp-reset":
+			result = append(result, "reject with tcp reset")
+		case "icmp-port-unreachable":
+			result = append(result, "reject with icmp type port-unreachable")
+		default:
+			return "", fmt.Errorf("reject with %s is not supported", targetOptions["--reject-with"])
+		}
+	case "DNAT":
+		result = append(result, "dnat to "+targetOptions["--to-destination"])
+	case "SNAT":
+		result = append(result, "snat to "+targetOptions["--to-source"])
+	default:
+		return "", fmt.Errorf("target %s is not supported", target)
+	}
+
+	return strings.Join(result, " "), nil
+}
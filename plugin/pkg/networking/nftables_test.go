@@ -0,0 +1,138 @@
+package networking
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToNftablesExpression(t *testing.T) {
+	tests := []struct {
+		name     string
+		ruleSpec []string
+		expected string
+		isValid  bool
+	}{
+		{
+			"masquerade",
+			[]string{"-s", "10.1.0.0/24", "!", "-o", "fl-abc", "-j", "MASQUERADE"},
+			`ip saddr 10.1.0.0/24 oifname != "fl-abc" masquerade`,
+			true,
+		},
+		{
+			"mark",
+			[]string{"-d", "10.1.0.2", "-p", "tcp", "-m", "tcp", "--dport", "80", "-j", "MARK", "--set-mark", "5"},
+			"ip daddr 10.1.0.2 meta l4proto tcp tcp dport 80 meta mark set 5",
+			true,
+		},
+		{
+			"negated mark",
+			[]string{"-d", "10.1.0.2", "!", "-p", "icmp", "-m", "mark", "!", "--mark", "5", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"},
+			"ip daddr 10.1.0.2 meta l4proto != icmp meta mark != 5 reject with icmp type port-unreachable",
+			true,
+		},
+		{
+			"tcp reset",
+			[]string{"-p", "tcp", "-j", "REJECT", "--reject-with", "tcp-reset"},
+			"meta l4proto tcp reject with tcp reset",
+			true,
+		},
+		{
+			"local addresses",
+			[]string{"-m", "addrtype", "--dst-type", "LOCAL", "-m", "mark", "--mark", "0", "-p", "udp", "-m", "udp", "--dport", "53", "-j", "MARK", "--set-mark", "7"},
+			"fib daddr type local meta mark 0 meta l4proto udp udp dport 53 meta mark set 7",
+			true,
+		},
+		{
+			"conntrack",
+			[]string{"-o", "fl-abc", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+			`oifname "fl-abc" ct state related,established accept`,
+			true,
+		},
+		{
+			"ping",
+			[]string{"-d", "10.1.0.2", "-p", "icmp", "--icmp-type", "echo-request", "-m", "mark", "!", "--mark", "5", "-j", "ACCEPT"},
+			"ip daddr 10.1.0.2 meta l4proto icmp icmp type echo-request meta mark != 5 accept",
+			true,
+		},
+		{
+			"dnat",
+			[]string{"-d", "127.0.0.11", "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", "10.1.0.1:5353"},
+			"ip daddr 127.0.0.11 meta l4proto udp udp dport 53 dnat to 10.1.0.1:5353",
+			true,
+		},
+		{"port without protocol", []string{"--dport", "80", "-j", "ACCEPT"}, "", false},
+		{"negated match", []string{"!", "-m", "mark", "-j", "ACCEPT"}, "", false},
+		{"option without value", []string{"-d"}, "", false},
+		{"unsupported option", []string{"--uid-owner", "0", "-j", "ACCEPT"}, "", false},
+		{"unsupported target", []string{"-j", "LOG"}, "", false},
+		{"unsupported reject", []string{"-j", "REJECT", "--reject-with", "icmp-host-prohibited"}, "", false},
+		{"mark without value", []string{"-j", "MARK"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := toNftablesExpression(test.ruleSpec)
+			if !test.isValid {
+				if err == nil {
+					t.Errorf("expected an error, got %s", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRequiresIptables(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     IptablesRule
+		expected bool
+	}{
+		{"masquerade", IptablesRule{Table: "nat", Chain: "POSTROUTING", RuleSpec: []string{"-s", "10.1.0.0/24", "-j", "MASQUERADE"}}, false},
+		{"mark", IptablesRule{Table: "mangle", Chain: "PREROUTING", RuleSpec: []string{"-d", "10.1.0.2", "-j", "MARK", "--set-mark", "5"}}, false},
+		{"reject", IptablesRule{Table: "filter", Chain: "INPUT", RuleSpec: []string{"-d", "10.1.0.2", "-j", "REJECT"}}, false},
+		{"accept in the filter table", IptablesRule{Table: "filter", Chain: "FORWARD", RuleSpec: []string{"-i", "fl-abc", "-j", "ACCEPT"}}, true},
+		{"accept of listed iptables rule", IptablesRule{Table: "filter", Chain: "INPUT", RuleSpec: []string{"-m", "mark", "--mark", "0x5", "-j", "ACCEPT"}}, true},
+		{"jump to a chain of docker", IptablesRule{Table: "filter", Chain: "FORWARD", RuleSpec: []string{"-o", "fl-abc", "-j", "DOCKER"}}, true},
+		{"rule in a chain of docker", IptablesRule{Table: "nat", Chain: "DOCKER", RuleSpec: []string{"-i", "fl-abc", "-j", "RETURN"}}, true},
+		{"isolation", IptablesRule{Table: "filter", Chain: "DOCKER-ISOLATION-STAGE-2", RuleSpec: []string{"-o", "fl-abc", "-j", "DROP"}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := requiresIptables(test.rule); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetNftablesComment(t *testing.T) {
+	longRuleSpec := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+	for len(strings.Join(longRuleSpec, " ")) <= nftablesCommentMaxLength {
+		longRuleSpec = append(longRuleSpec, "-s", "10.1.0.0/24")
+	}
+	otherLongRuleSpec := append(append([]string{}, longRuleSpec...), "-j", "ACCEPT")
+
+	short := getNftablesComment([]string{"-d", "10.1.0.2", "-j", "ACCEPT"})
+	if short != "-d 10.1.0.2 -j ACCEPT" {
+		t.Errorf("expected short rules to be unchanged, got %s", short)
+	}
+
+	long := getNftablesComment(longRuleSpec)
+	if len(long) > nftablesCommentMaxLength {
+		t.Errorf("expected at most %d characters, got %d: %s", nftablesCommentMaxLength, len(long), long)
+	}
+	if long == getNftablesComment(otherLongRuleSpec) {
+		t.Errorf("expected different comments for rules with the same beginning, got %s", long)
+	}
+	if listed := getNftablesComment(strings.Fields(long)); listed != long {
+		t.Errorf("expected the listed rule to keep its comment %s, got %s", long, listed)
+	}
+}
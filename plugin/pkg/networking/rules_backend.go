@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"fmt"
+)
+
+// Backends that program the rules of the plugin in the host network namespace
+const (
+	RulesBackendIptables = "iptables"
+	RulesBackendNftables = "nftables"
+)
+
+// RulesBackend programs rules in iptables syntax
+type RulesBackend interface {
+	// Apply creates or deletes the rules. action is "create" or "delete". Creating an existing rule does nothing
+	Apply(rules []IptablesRule, action string) error
+	// List returns the rules of a chain, which the backend knows. Missing chains have no rules. The rule specs of
+	// the nftables backend can be truncated, but deleting them with Apply works
+	List(table, chain string) ([]IptablesRule, error)
+}
+
+var rulesBackend RulesBackend = &iptablesBackend{}
+
+// SetRulesBackend selects the backend of ApplyIpTablesRules and ListRules. It must be called before any rules
+// are applied
+func SetRulesBackend(name string) error {
+	switch name {
+	case RulesBackendIptables:
+		rulesBackend = &iptablesBackend{}
+	case RulesBackendNftables:
+		rulesBackend = newNftablesBackend()
+	default:
+		return fmt.Errorf("unknown rules backend %s, must be %s or %s", name, RulesBackendIptables, RulesBackendNftables)
+	}
+
+	return nil
+}
+
+// ListRules lists the rules of a chain with the configured rules backend
+func ListRules(table, chain string) ([]IptablesRule, error) {
+	return rulesBackend.List(table, chain)
+}
@@ -206,7 +206,8 @@ func generateRandomSuffix(length int) (string, error) {
 
 func cleanUpStaleFwmarks(etcdClient etcd.Client, existingServices []string) ([]uint32, error) {
 	return etcd.WithConnection(etcdClient, func(connection *etcd.Connection) ([]uint32, error) {
-		fwmarks := map[string]uint32{}
+		// A service has a fwmark per network and per published port
+		fwmarks := map[uint32]struct{}{}
 		prefix := etcdClient.GetKey()
 		resp, err := connection.Client.Get(connection.Ctx, prefix, clientv3.WithPrefix())
 		if err != nil {
@@ -236,7 +237,7 @@ func cleanUpStaleFwmarks(etcdClient etcd.Client, existingServices []string) ([]u
 					log.Printf("Failed to parse existing fwmark %s, skipping: %v", string(kv.Key), err)
 					continue
 				}
-				fwmarks[serviceID] = uint32(parsedFwmark)
+				fwmarks[uint32(parsedFwmark)] = struct{}{}
 
 				fmt.Printf("Deleting fwmark data at %s for non-existing service %s\n", string(kv.Key), serviceID)
 				_, err = connection.Client.Delete(connection.Ctx, string(kv.Key))
@@ -248,6 +249,6 @@ func cleanUpStaleFwmarks(etcdClient etcd.Client, existingServices []string) ([]u
 			}
 		}
 
-		return maps.Values(fwmarks), nil
+		return maps.Keys(fwmarks), nil
 	})
 }
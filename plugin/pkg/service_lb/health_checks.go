@@ -0,0 +1,307 @@
+package service_lb
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of the active health checks of the backends of a service
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthCheckRetries  = 3
+	// maxHealthCheckBackoff limits the interval in which unhealthy backends are checked
+	maxHealthCheckBackoff = time.Minute
+)
+
+// HealthCheckConfig configures the active health checks that each node runs against the backends of its
+// load balancers of a service
+type HealthCheckConfig struct {
+	// Type is HealthCheckTCP, HealthCheckHTTP or empty if the backends aren't checked
+	Type string
+	Port uint32
+	// Path is the path of HTTP health checks
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Retries is the number of consecutive failed checks after which a backend is unhealthy
+	Retries int
+}
+
+// NewHealthCheckConfig creates the config from the labels flannel-np.lb-health-check* of a service. The port
+// defaults to the first TCP port of the service. Invalid values are logged and ignored
+func NewHealthCheckConfig(labels map[string]string, ports []common.ServicePort) HealthCheckConfig {
+	result := HealthCheckConfig{
+		Type:     strings.ToLower(labels[common.LBHealthCheckLabel]),
+		Path:     labels[common.LBHealthCheckPathLabel],
+		Interval: defaultHealthCheckInterval,
+		Timeout:  defaultHealthCheckTimeout,
+		Retries:  defaultHealthCheckRetries,
+	}
+
+	switch result.Type {
+	case "":
+		return HealthCheckConfig{}
+	case HealthCheckTCP, HealthCheckHTTP:
+	default:
+		log.Printf("Ignoring invalid value %s of label %s", result.Type, common.LBHealthCheckLabel)
+		return HealthCheckConfig{}
+	}
+
+	result.Port = common.ParseUint32Label(labels, common.LBHealthCheckPortLabel)
+	if result.Port == 0 {
+		tcpPort, exists := lo.Find(ports, func(item common.ServicePort) bool {
+			return item.Protocol == "" || strings.ToLower(item.Protocol) == "tcp"
+		})
+		if !exists {
+			log.Printf("Disabling health checks, because label %s is missing and the service has no TCP port", common.LBHealthCheckPortLabel)
+			return HealthCheckConfig{}
+		}
+		result.Port = tcpPort.TargetPort
+	}
+
+	if !strings.HasPrefix(result.Path, "/") {
+		result.Path = "/" + result.Path
+	}
+	if interval := common.ParseUint32Label(labels, common.LBHealthCheckIntervalLabel); interval > 0 {
+		result.Interval = time.Duration(interval) * time.Second
+	}
+	if timeout := common.ParseUint32Label(labels, common.LBHealthCheckTimeoutLabel); timeout > 0 {
+		result.Timeout = time.Duration(timeout) * time.Second
+	}
+	if retries := common.ParseUint32Label(labels, common.LBHealthCheckRetriesLabel); retries > 0 {
+		result.Retries = int(retries)
+	}
+
+	return result
+}
+
+// BackendHealth is the result of the health checks of a backend, as published to etcd
+type BackendHealth struct {
+	ContainerID     string    `json:"ContainerID"`
+	DockerNetworkID string    `json:"DockerNetworkID"`
+	Healthy         bool      `json:"Healthy"`
+	Since           time.Time `json:"Since"`           // time of the last change of Healthy
+	Error           string    `json:"Error,omitempty"` // error of the last failed check, if unhealthy
+}
+
+type backendsHealth struct {
+	Backends map[string]BackendHealth `json:"Backends"` // backend IP -> health
+}
+
+func (h backendsHealth) Equals(other common.Equaler) bool {
+	o, ok := other.(backendsHealth)
+	if !ok {
+		return false
+	}
+
+	return maps.EqualFunc(h.Backends, o.Backends, func(a, b BackendHealth) bool {
+		return a.ContainerID == b.ContainerID && a.DockerNetworkID == b.DockerNetworkID && a.Healthy == b.Healthy &&
+			a.Since.Equal(b.Since) && a.Error == b.Error
+	})
+}
+
+// healthChecker checks the backends of the load balancers of one service. Backends start as healthy
+type healthChecker struct {
+	serviceID        string
+	config           HealthCheckConfig
+	httpClient       *http.Client
+	setBackendHealth func(dockerNetworkID string, ip net.IP, healthy bool) error
+	publish          func(health map[string]BackendHealth) error
+	probes           map[string]context.CancelFunc // backend IP -> stops the checks
+	results          map[string]BackendHealth      // backend IP -> health
+	sync.Mutex
+}
+
+func newHealthChecker(serviceID string, config HealthCheckConfig,
+	setBackendHealth func(dockerNetworkID string, ip net.IP, healthy bool) error,
+	publish func(health map[string]BackendHealth) error) *healthChecker {
+	return &healthChecker{
+		serviceID: serviceID,
+		config:    config,
+		httpClient: &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true},
+			// A redirect is a sign of life, we don't need to follow it
+			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		setBackendHealth: setBackendHealth,
+		publish:          publish,
+		probes:           make(map[string]context.CancelFunc),
+		results:          make(map[string]BackendHealth),
+	}
+}
+
+// AddContainer starts checking the IPs of the container. ips: docker network ID -> IP
+func (c *healthChecker) AddContainer(containerID string, ips map[string]net.IP) {
+	c.Lock()
+	defer c.Unlock()
+
+	for dockerNetworkID, ip := range ips {
+		key := ip.String()
+		if _, exists := c.probes[key]; exists {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		c.probes[key] = cancel
+		c.results[key] = BackendHealth{
+			ContainerID:     containerID,
+			DockerNetworkID: dockerNetworkID,
+			Healthy:         true,
+			Since:           time.Now(),
+		}
+		go c.probe(ctx, containerID, dockerNetworkID, ip)
+	}
+
+	c.publishResults()
+}
+
+func (c *healthChecker) RemoveContainer(ips map[string]net.IP) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, ip := range ips {
+		key := ip.String()
+		if cancel, exists := c.probes[key]; exists {
+			cancel()
+			delete(c.probes, key)
+			delete(c.results, key)
+		}
+	}
+
+	c.publishResults()
+}
+
+// Stop stops all checks. It doesn't restore the health of the backends
+func (c *healthChecker) Stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, cancel := range c.probes {
+		cancel()
+	}
+	c.probes = make(map[string]context.CancelFunc)
+}
+
+// IsHealthy returns false if the backend failed its health checks
+func (c *healthChecker) IsHealthy(ip net.IP) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	result, exists := c.results[ip.String()]
+	return !exists || result.Healthy
+}
+
+// probe checks the backend until ctx is cancelled. Unhealthy backends are checked with exponential backoff
+func (c *healthChecker) probe(ctx context.Context, containerID, dockerNetworkID string, ip net.IP) {
+	healthy := true
+	failures := 0
+	delay := c.config.Interval
+
+	for {
+		err := c.check(ctx, ip)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			failures = 0
+			delay = c.config.Interval
+			if !healthy {
+				healthy = true
+				fmt.Printf("Backend %s of service %s recovered\n", ip, c.serviceID)
+				c.report(ctx, containerID, dockerNetworkID, ip, true, nil)
+			}
+		} else {
+			failures++
+			if healthy && failures >= c.config.Retries {
+				healthy = false
+				log.Printf("Backend %s of service %s failed %d health checks, last error: %v\n", ip, c.serviceID, failures, err)
+				c.report(ctx, containerID, dockerNetworkID, ip, false, err)
+			}
+			if !healthy {
+				delay = min(delay*2, maxHealthCheckBackoff)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *healthChecker) check(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(c.config.Port), 10))
+	if c.config.Type == HealthCheckHTTP {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+c.config.Path, nil)
+		if err != nil {
+			return err
+		}
+		response, err := c.httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return fmt.Errorf("unexpected HTTP status %s", response.Status)
+		}
+		return nil
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *healthChecker) report(ctx context.Context, containerID, dockerNetworkID string, ip net.IP, healthy bool, checkErr error) {
+	c.Lock()
+	if ctx.Err() != nil {
+		// The checks were stopped while we checked
+		c.Unlock()
+		return
+	}
+	result := BackendHealth{
+		ContainerID:     containerID,
+		DockerNetworkID: dockerNetworkID,
+		Healthy:         healthy,
+		Since:           time.Now(),
+	}
+	if checkErr != nil {
+		result.Error = checkErr.Error()
+	}
+	c.results[ip.String()] = result
+	c.publishResults()
+	c.Unlock()
+
+	if err := c.setBackendHealth(dockerNetworkID, ip, healthy); err != nil {
+		log.Printf("Error setting health of backend %s of service %s to %t: %v\n", ip, c.serviceID, healthy, err)
+	}
+}
+
+// publishResults expects the lock to be held
+func (c *healthChecker) publishResults() {
+	if err := c.publish(maps.Clone(c.results)); err != nil {
+		log.Printf("Error publishing health check results of service %s: %v\n", c.serviceID, err)
+	}
+}
@@ -0,0 +1,182 @@
+package service_lb
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/networking"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ipvsConntrackPath = "/proc/sys/net/ipv4/vs/conntrack"
+
+// IngressLb load balances a published port of a service. It accepts the traffic to the port on all local addresses
+// of the node and forwards it to the backends in one flannel network of the service
+type IngressLb interface {
+	AddBackend(ip net.IP) error
+	RemoveBackend(ip net.IP) error
+	DrainBackend(ip net.IP) error
+	SetBackendHealth(ip net.IP, healthy bool) error
+	SetBackendWeight(ip net.IP, weight int) error
+	SetBackends(ips []net.IP) error
+	Delete() error
+	GetFwmark() uint32
+	GetDockerNetworkID() string
+	GetPort() common.ServicePort
+	SetIpvsServiceConfig(config IpvsServiceConfig) error
+}
+
+type ingressLb struct {
+	*serviceLb
+	port common.ServicePort
+}
+
+// NewIngressLb creates the IPVS service and the iptables rules of the published port. The backends are reached
+// via their IPs in the network with dockerNetworkID
+func NewIngressLb(dockerNetworkID, serviceID string, fwmark uint32, port common.ServicePort, ipvsConfig IpvsServiceConfig, drainTimeout time.Duration) (IngressLb, error) {
+	if port.TargetPort == 0 || port.TargetPort > 65535 {
+		return nil, fmt.Errorf("invalid target port %d of published port %d of service %s", port.TargetPort, port.PublishedPort, serviceID)
+	}
+
+	lb := &ingressLb{
+		serviceLb: &serviceLb{
+			dockerNetworkID:   dockerNetworkID,
+			serviceID:         serviceID,
+			fwmark:            fwmark,
+			backendIPs:        make([]net.IP, 0),
+			backendPort:       uint16(port.TargetPort),
+			ipvsConfig:        ipvsConfig,
+			ports:             []common.ServicePort{port},
+			drainTimeout:      drainTimeout,
+			drainingBackends:  make(map[string]*time.Timer),
+			drainedBackends:   make(map[string]struct{}),
+			unhealthyBackends: make(map[string]struct{}),
+			weights:           make(map[string]int),
+		},
+		port: port,
+	}
+
+	if err := ensureIpvsConntrack(); err != nil {
+		return nil, err
+	}
+
+	if _, err := lb.ensureIpvsService(); err != nil {
+		return nil, errors.WithMessagef(err, "error creating IPVS service of ingress load balancer for port %d of service %s", port.PublishedPort, serviceID)
+	}
+
+	lb.iptablesRules = lb.getIngressIptablesRules()
+	err := networking.ApplyIpTablesRules(lb.iptablesRules, "create")
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to setup IP Tables rules for ingress load balancer for port %d of service %s", port.PublishedPort, serviceID)
+	}
+
+	return lb, nil
+}
+
+// ensureIpvsConntrack enables the connection tracking of IPVS in the host network namespace. IPVS only passes its
+// connections through the nat table, i.e. our MASQUERADE rule, with conntrack enabled. The setting applies to
+// all IPVS services of the host, so we only change it if it's disabled
+func ensureIpvsConntrack() error {
+	value, err := os.ReadFile(ipvsConntrackPath)
+	if err != nil {
+		return errors.WithMessage(err, "Error reading conntrack setting of IPVS")
+	}
+	if strings.TrimSpace(string(value)) != "0" {
+		return nil
+	}
+
+	fmt.Printf("Enabling conntrack of IPVS via %s for the ingress load balancers\n", ipvsConntrackPath)
+	if err := os.WriteFile(ipvsConntrackPath, []byte("1"), 0644); err != nil {
+		return errors.WithMessage(err, "Error enabling conntrack of IPVS")
+	}
+
+	return nil
+}
+
+func (lb *ingressLb) GetDockerNetworkID() string  { return lb.dockerNetworkID }
+func (lb *ingressLb) GetPort() common.ServicePort { return lb.port }
+
+func (lb *ingressLb) Delete() error {
+	lb.Lock()
+	defer lb.Unlock()
+
+	return lb.deleteIpvsServiceAndIptablesRules()
+}
+
+// getIngressIptablesRules marks the traffic to the published port on the local addresses of the node for IPVS. The
+// VIPs are local addresses as well, so we only mark traffic that the rules of the VIPs didn't mark.
+// The traffic is masqueraded, so the replies of backends on other nodes come back through this node
+func (lb *ingressLb) getIngressIptablesRules() []networking.IptablesRule {
+	fwmarkStr := strconv.FormatUint(uint64(lb.fwmark), 10)
+	protocol := getProtocol(lb.port)
+	match := []string{
+		"-m", "addrtype",
+		"--dst-type", "LOCAL",
+		"-m", "mark",
+		"--mark", "0",
+		"-p", protocol,
+		"-m", protocol,
+		"--dport", strconv.FormatUint(uint64(lb.port.PublishedPort), 10),
+	}
+
+	return []networking.IptablesRule{
+		{
+			Table:    "mangle",
+			Chain:    "PREROUTING",
+			RuleSpec: append(slices.Clone(match), "-j", "MARK", "--set-mark", fwmarkStr),
+		},
+		{
+			// Connections from the node itself
+			Table:    "mangle",
+			Chain:    "OUTPUT",
+			RuleSpec: append(slices.Clone(match), "-j", "MARK", "--set-mark", fwmarkStr),
+		},
+		{
+			Table: "filter",
+			Chain: "INPUT",
+			RuleSpec: []string{
+				"-m", "mark",
+				"--mark", fwmarkStr,
+				"-j", "ACCEPT",
+			},
+		},
+		{
+			Table: "nat",
+			Chain: "POSTROUTING",
+			RuleSpec: []string{
+				"-m", "mark",
+				"--mark", fwmarkStr,
+				"-j", "MASQUERADE",
+			},
+		},
+	}
+}
+
+// getProtocol returns the lower case protocol of the port, tcp if it isn't set
+func getProtocol(port common.ServicePort) string {
+	protocol := strings.ToLower(port.Protocol)
+	if protocol == "" {
+		return "tcp"
+	}
+
+	return protocol
+}
+
+// getIngressPorts returns the ports of the service that are published via the routing mesh
+func getIngressPorts(ports []common.ServicePort) []common.ServicePort {
+	return lo.Filter(ports, func(item common.ServicePort, index int) bool {
+		return item.PublishedPort != 0 && (item.PublishMode == "" || strings.ToLower(item.PublishMode) == "ingress")
+	})
+}
+
+// getIngressKey identifies a published port of a service. We also use it in place of the network ID to get the
+// fwmark of the ingress load balancer
+func getIngressKey(port common.ServicePort) string {
+	return fmt.Sprintf("ingress-%s-%d", getProtocol(port), port.PublishedPort)
+}
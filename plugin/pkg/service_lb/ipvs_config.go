@@ -0,0 +1,126 @@
+package service_lb
+
+import (
+	"encoding/binary"
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Flags of an IPVS service, see include/uapi/linux/ip_vs.h
+const (
+	ipvsSvcFlagPersistent = 0x0001
+	ipvsSvcFlagHashed     = 0x0002
+	ipvsSvcFlagSched1     = 0x0008
+	ipvsSvcFlagSched2     = 0x0010
+	ipvsSvcFlagSched3     = 0x0020
+)
+
+const (
+	defaultIpvsScheduler = "rr"
+	// defaultPersistenceTimeout is the same as the one of ipvsadm
+	defaultPersistenceTimeout = 300
+)
+
+// ipvsSchedulers are the schedulers of the kernel. The kernel loads the module of a scheduler on first use
+var ipvsSchedulers = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq", "fo", "ovf", "mh", "twos"}
+
+// ipvsSchedulerFlags are the names of the scheduler flags as used by ipvsadm. The generic names flag-1 to flag-3
+// work with all schedulers
+var ipvsSchedulerFlags = map[string]uint32{
+	"flag-1":      ipvsSvcFlagSched1,
+	"flag-2":      ipvsSvcFlagSched2,
+	"flag-3":      ipvsSvcFlagSched3,
+	"sh-fallback": ipvsSvcFlagSched1,
+	"sh-port":     ipvsSvcFlagSched2,
+	"mh-fallback": ipvsSvcFlagSched1,
+	"mh-port":     ipvsSvcFlagSched2,
+}
+
+// IpvsServiceConfig is the part of the IPVS service of a load balancer that is configured per service via labels
+type IpvsServiceConfig struct {
+	SchedName string
+	Flags     uint32
+	// Timeout is the persistence timeout in seconds
+	Timeout uint32
+	// Netmask is the persistence netmask in network byte order, as expected by the kernel
+	Netmask uint32
+}
+
+// NewIpvsServiceConfig creates the config from the labels flannel-np.lb-scheduler,
+// flannel-np.lb-scheduler-flags and flannel-np.lb-persistence* of a service. Invalid values are logged and ignored
+func NewIpvsServiceConfig(labels map[string]string) IpvsServiceConfig {
+	result := IpvsServiceConfig{
+		SchedName: defaultIpvsScheduler,
+		Netmask:   toIpvsNetmask(net.CIDRMask(32, 32)),
+	}
+
+	if scheduler := strings.ToLower(strings.TrimSpace(labels[common.LBSchedulerLabel])); scheduler != "" {
+		if lo.Contains(ipvsSchedulers, scheduler) {
+			result.SchedName = scheduler
+		} else {
+			log.Printf("Ignoring invalid value %s of label %s", scheduler, common.LBSchedulerLabel)
+		}
+	}
+
+	for _, flag := range strings.Split(labels[common.LBSchedulerFlagsLabel], ",") {
+		flag = strings.ToLower(strings.TrimSpace(flag))
+		if flag == "" {
+			continue
+		}
+		value, exists := ipvsSchedulerFlags[flag]
+		if !exists {
+			log.Printf("Ignoring invalid value %s of label %s", flag, common.LBSchedulerFlagsLabel)
+			continue
+		}
+		result.Flags |= value
+	}
+
+	if strings.ToLower(labels[common.LBPersistenceLabel]) == "true" {
+		result.Flags |= ipvsSvcFlagPersistent
+		result.Timeout = common.ParseUint32Label(labels, common.LBPersistenceTimeoutLabel)
+		if result.Timeout == 0 {
+			result.Timeout = defaultPersistenceTimeout
+		}
+		if netmask := labels[common.LBPersistenceNetmaskLabel]; netmask != "" {
+			if mask := parseNetmask(netmask); mask != nil {
+				result.Netmask = toIpvsNetmask(mask)
+			} else {
+				log.Printf("Ignoring invalid value %s of label %s", netmask, common.LBPersistenceNetmaskLabel)
+			}
+		}
+	}
+
+	return result
+}
+
+// parseNetmask parses netmasks like 255.255.255.0, 24 or /24. Returns nil if the netmask is invalid
+func parseNetmask(value string) net.IPMask {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "/")
+	if prefixLength, err := strconv.Atoi(value); err == nil {
+		if prefixLength < 0 || prefixLength > 32 {
+			return nil
+		}
+		return net.CIDRMask(prefixLength, 32)
+	}
+
+	ip := net.ParseIP(value).To4()
+	if ip == nil {
+		return nil
+	}
+	mask := net.IPMask(ip)
+	if _, bits := mask.Size(); bits == 0 {
+		// Not a canonical netmask, e.g. 255.0.255.0
+		return nil
+	}
+
+	return mask
+}
+
+// toIpvsNetmask returns the netmask in network byte order. The IPVS library sends the value in native byte order
+func toIpvsNetmask(mask net.IPMask) uint32 {
+	return binary.NativeEndian.Uint32(mask)
+}
@@ -5,21 +5,40 @@ import (
 	"github.com/moby/ipvs"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
 	"github.com/sovarto/FlannelNetworkPlugin/pkg/networking"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
+	"log"
 	"net"
+	"slices"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type NetworkSpecificServiceLb interface {
 	AddBackend(ip net.IP) error
 	RemoveBackend(ip net.IP) error
+	// DrainBackend sets the weight of the backend to 0, so that it receives no new connections. The existing
+	// connections continue until the backend is removed, at the latest after the drain timeout
+	DrainBackend(ip net.IP) error
+	// SetBackendHealth sets the weight of a backend that failed its health checks to 0 and restores it
+	// after the backend recovered
+	SetBackendHealth(ip net.IP, healthy bool) error
+	// SetBackendWeight sets the IPVS weight of a backend. The weight of backends that aren't added yet is applied
+	// when they are added
+	SetBackendWeight(ip net.IP, weight int) error
 	SetBackends(ips []net.IP) error
 	Delete() error
 	GetFrontendIP() net.IP
 	GetFwmark() uint32
 	UpdateFrontendIP(ip net.IP) error
+	// SetIpvsServiceConfig updates the existing IPVS service in place, without affecting the backends
+	SetIpvsServiceConfig(config IpvsServiceConfig) error
+	// SetPorts restricts the load balancing to the given ports of the service and rejects the traffic to the
+	// other ports of the frontend IP. Without ports, all TCP and UDP traffic to the frontend IP is load balanced
+	SetPorts(ports []common.ServicePort) error
 }
 
 type serviceLb struct {
@@ -30,22 +49,48 @@ type serviceLb struct {
 	backendIPs      []net.IP
 	iptablesRules   []networking.IptablesRule
 	link            netlink.Link
+	// backendPort is the port of the backends that IPVS forwards to. 0 keeps the port of the request
+	backendPort  uint16
+	ipvsConfig   IpvsServiceConfig
+	ports        []common.ServicePort
+	drainTimeout time.Duration
+	// drainingBackends backend IP -> timer that removes the backend after the drain timeout
+	drainingBackends map[string]*time.Timer
+	// drainedBackends backend IPs that were removed after the drain timeout, but that haven't been removed
+	// via RemoveBackend yet
+	drainedBackends map[string]struct{}
+	// unhealthyBackends backend IPs that failed their health checks
+	unhealthyBackends map[string]struct{}
+	// weights backend IP -> weight, if it isn't the default weight of 1
+	weights map[string]int
+	sync.Mutex
 }
 
-func NewNetworkSpecificServiceLb(link netlink.Link, dockerNetworkID, serviceID string, fwmark uint32) NetworkSpecificServiceLb {
+// NewNetworkSpecificServiceLb drainTimeout of 0 keeps draining backends until they are removed
+func NewNetworkSpecificServiceLb(link netlink.Link, dockerNetworkID, serviceID string, fwmark uint32, ipvsConfig IpvsServiceConfig, ports []common.ServicePort, drainTimeout time.Duration) NetworkSpecificServiceLb {
 
 	slb := &serviceLb{
-		dockerNetworkID: dockerNetworkID,
-		serviceID:       serviceID,
-		fwmark:          fwmark,
-		backendIPs:      make([]net.IP, 0),
-		link:            link,
+		dockerNetworkID:   dockerNetworkID,
+		serviceID:         serviceID,
+		fwmark:            fwmark,
+		backendIPs:        make([]net.IP, 0),
+		link:              link,
+		ipvsConfig:        ipvsConfig,
+		ports:             slices.Clone(ports),
+		drainTimeout:      drainTimeout,
+		drainingBackends:  make(map[string]*time.Timer),
+		drainedBackends:   make(map[string]struct{}),
+		unhealthyBackends: make(map[string]struct{}),
+		weights:           make(map[string]int),
 	}
 
 	return slb
 }
 
 func (slb *serviceLb) UpdateFrontendIP(ip net.IP) error {
+	slb.Lock()
+	defer slb.Unlock()
+
 	err := networking.EnsureInterfaceListensOnAddress(slb.link, ip.String())
 	if err != nil {
 		return errors.WithMessagef(err, "Failed to ensure load balancer interface %s listening on %s", slb.link.Attrs().Name, ip.String())
@@ -71,7 +116,50 @@ func (slb *serviceLb) UpdateFrontendIP(ip net.IP) error {
 func (slb *serviceLb) GetFrontendIP() net.IP { return slb.frontendIP }
 func (slb *serviceLb) GetFwmark() uint32     { return slb.fwmark }
 
+func (slb *serviceLb) SetIpvsServiceConfig(config IpvsServiceConfig) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	if config == slb.ipvsConfig {
+		return nil
+	}
+
+	fmt.Printf("Changing IPVS config of service %s and network %s from %+v to %+v\n", slb.serviceID, slb.dockerNetworkID, slb.ipvsConfig, config)
+	slb.ipvsConfig = config
+	if _, err := slb.ensureIpvsService(); err != nil {
+		return errors.WithMessagef(err, "error updating IPVS service of service load balancer for service %s and network %s", slb.serviceID, slb.dockerNetworkID)
+	}
+
+	return nil
+}
+
+func (slb *serviceLb) SetPorts(ports []common.ServicePort) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	if slices.Equal(ports, slb.ports) {
+		return nil
+	}
+
+	slb.ports = slices.Clone(ports)
+	if slb.frontendIP == nil {
+		return nil
+	}
+
+	err := slb.ensureServiceLoadBalancerFrontend()
+	if err != nil {
+		return errors.WithMessagef(err, "error updating ports of service load balancer for service %s and network %s", slb.serviceID, slb.dockerNetworkID)
+	}
+
+	return nil
+}
+
 func (slb *serviceLb) AddBackend(ip net.IP) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	slb.stopDraining(ip)
+
 	svc, err := slb.ensureIpvsService()
 	if err != nil {
 		return err
@@ -91,8 +179,8 @@ func (slb *serviceLb) AddBackend(ip net.IP) error {
 	err = handle.NewDestination(svc, &ipvs.Destination{
 		AddressFamily:   unix.AF_INET,
 		Address:         ip,
-		Port:            0,
-		Weight:          1,
+		Port:            slb.backendPort,
+		Weight:          slb.getBackendWeight(ip),
 		ConnectionFlags: 0,
 	})
 
@@ -100,19 +188,38 @@ func (slb *serviceLb) AddBackend(ip net.IP) error {
 		err = handle.UpdateDestination(svc, &ipvs.Destination{
 			AddressFamily:   unix.AF_INET,
 			Address:         ip,
-			Port:            0,
-			Weight:          1,
+			Port:            slb.backendPort,
+			Weight:          slb.getBackendWeight(ip),
 			ConnectionFlags: 0,
 		})
-
-		return errors.WithMessagef(err, "Error updating IPVS")
+		if err != nil {
+			return errors.WithMessagef(err, "Error updating IPVS")
+		}
 	}
 
-	slb.backendIPs = append(slb.backendIPs, ip)
+	// The destination already exists e.g. after a restart of the plugin, because we reuse the IPVS service
+	if !slb.isBackend(ip) {
+		slb.backendIPs = append(slb.backendIPs, ip)
+	}
 
-	return err
+	return nil
 }
+
 func (slb *serviceLb) RemoveBackend(ip net.IP) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	delete(slb.unhealthyBackends, ip.String())
+	delete(slb.weights, ip.String())
+	if wasRemoved := slb.stopDraining(ip); wasRemoved || !slb.isBackend(ip) {
+		// The backend may also have been filtered by the traffic policy of the service
+		return nil
+	}
+
+	return slb.removeBackend(ip)
+}
+
+func (slb *serviceLb) removeBackend(ip net.IP) error {
 	svc, err := slb.ensureIpvsService()
 	if err != nil {
 		return err
@@ -132,7 +239,7 @@ func (slb *serviceLb) RemoveBackend(ip net.IP) error {
 	err = handle.DelDestination(svc, &ipvs.Destination{
 		AddressFamily:   unix.AF_INET,
 		Address:         ip,
-		Port:            0,
+		Port:            slb.backendPort,
 		Weight:          1,
 		ConnectionFlags: 0,
 	})
@@ -148,7 +255,165 @@ func (slb *serviceLb) RemoveBackend(ip net.IP) error {
 	return nil
 }
 
+func (slb *serviceLb) DrainBackend(ip net.IP) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	key := ip.String()
+	_, isDraining := slb.drainingBackends[key]
+	_, wasDrained := slb.drainedBackends[key]
+	if isDraining || wasDrained || !slb.isBackend(ip) {
+		return nil
+	}
+
+	err := slb.updateBackendWeight(ip, 0)
+	if err != nil {
+		return errors.WithMessagef(err, "error draining backend %s of service load balancer for service %s and network %s", ip, slb.serviceID, slb.dockerNetworkID)
+	}
+
+	fmt.Printf("Draining backend %s of service load balancer for service %s and network %s\n", ip, slb.serviceID, slb.dockerNetworkID)
+
+	var timer *time.Timer
+	if slb.drainTimeout > 0 {
+		timer = time.AfterFunc(slb.drainTimeout, func() {
+			slb.Lock()
+			defer slb.Unlock()
+
+			// The backend may have been removed or added again in the meantime
+			if slb.drainingBackends[key] != timer {
+				return
+			}
+			delete(slb.drainingBackends, key)
+
+			fmt.Printf("Drain timeout of backend %s of service load balancer for service %s and network %s passed. Removing it...\n", ip, slb.serviceID, slb.dockerNetworkID)
+			if err := slb.removeBackend(ip); err != nil {
+				log.Printf("Error removing backend %s after drain timeout: %v\n", ip, err)
+				return
+			}
+			slb.drainedBackends[key] = struct{}{}
+		})
+	}
+	slb.drainingBackends[key] = timer
+
+	return nil
+}
+
+func (slb *serviceLb) SetBackendHealth(ip net.IP, healthy bool) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	key := ip.String()
+	_, wasUnhealthy := slb.unhealthyBackends[key]
+	if healthy != wasUnhealthy {
+		return nil
+	}
+	if healthy {
+		delete(slb.unhealthyBackends, key)
+	} else {
+		slb.unhealthyBackends[key] = struct{}{}
+	}
+
+	_, isDraining := slb.drainingBackends[key]
+	_, wasDrained := slb.drainedBackends[key]
+	if isDraining || wasDrained || !slb.isBackend(ip) {
+		// The weight of draining backends stays 0. Other backends get their weight when they are added
+		return nil
+	}
+
+	err := slb.updateBackendWeight(ip, slb.getBackendWeight(ip))
+	if err != nil {
+		return errors.WithMessagef(err, "error updating health of backend %s of service load balancer for service %s and network %s", ip, slb.serviceID, slb.dockerNetworkID)
+	}
+
+	return nil
+}
+
+func (slb *serviceLb) SetBackendWeight(ip net.IP, weight int) error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	key := ip.String()
+	if weight <= 0 {
+		weight = 1
+	}
+	if previous, exists := slb.weights[key]; (exists && previous == weight) || (!exists && weight == 1) {
+		return nil
+	}
+	slb.weights[key] = weight
+
+	_, isDraining := slb.drainingBackends[key]
+	_, wasDrained := slb.drainedBackends[key]
+	if isDraining || wasDrained || !slb.isBackend(ip) {
+		return nil
+	}
+
+	fmt.Printf("Changing weight of backend %s of service load balancer for service %s and network %s to %d\n", ip, slb.serviceID, slb.dockerNetworkID, weight)
+	err := slb.updateBackendWeight(ip, slb.getBackendWeight(ip))
+	if err != nil {
+		return errors.WithMessagef(err, "error updating weight of backend %s of service load balancer for service %s and network %s", ip, slb.serviceID, slb.dockerNetworkID)
+	}
+
+	return nil
+}
+
+func (slb *serviceLb) isBackend(ip net.IP) bool {
+	return lo.ContainsBy(slb.backendIPs, func(item net.IP) bool { return item.Equal(ip) })
+}
+
+// getBackendWeight returns the IPVS weight of a backend that is not draining
+func (slb *serviceLb) getBackendWeight(ip net.IP) int {
+	if _, isUnhealthy := slb.unhealthyBackends[ip.String()]; isUnhealthy {
+		return 0
+	}
+	if weight, exists := slb.weights[ip.String()]; exists {
+		return weight
+	}
+
+	return 1
+}
+
+func (slb *serviceLb) updateBackendWeight(ip net.IP, weight int) error {
+	svc, err := slb.ensureIpvsService()
+	if err != nil {
+		return err
+	}
+
+	handle, err := ipvs.New("")
+	if err != nil {
+		return fmt.Errorf("failed to initialize IPVS handle: %v", err)
+	}
+	defer handle.Close()
+
+	return handle.UpdateDestination(svc, &ipvs.Destination{
+		AddressFamily:   unix.AF_INET,
+		Address:         ip,
+		Port:            slb.backendPort,
+		Weight:          weight,
+		ConnectionFlags: 0,
+	})
+}
+
+// stopDraining forgets the draining state of the backend. Returns true if the backend was already removed
+// after the drain timeout
+func (slb *serviceLb) stopDraining(ip net.IP) (wasRemoved bool) {
+	key := ip.String()
+	if timer, isDraining := slb.drainingBackends[key]; isDraining {
+		if timer != nil {
+			timer.Stop()
+		}
+		delete(slb.drainingBackends, key)
+	}
+
+	_, wasRemoved = slb.drainedBackends[key]
+	delete(slb.drainedBackends, key)
+
+	return wasRemoved
+}
+
 func (slb *serviceLb) SetBackends(ips []net.IP) error {
+	slb.Lock()
+	defer slb.Unlock()
+
 	svc, err := slb.ensureIpvsService()
 	if err != nil {
 		return err
@@ -178,6 +443,10 @@ func (slb *serviceLb) SetBackends(ips []net.IP) error {
 
 	desiredIPs := make(map[string]net.IP)
 	for _, ip := range ips {
+		// Backends that were removed after the drain timeout stay removed
+		if _, wasDrained := slb.drainedBackends[ip.String()]; wasDrained {
+			continue
+		}
 		desiredIPs[ip.String()] = ip
 	}
 
@@ -185,9 +454,10 @@ func (slb *serviceLb) SetBackends(ips []net.IP) error {
 	for ipStr, ip := range desiredIPs {
 		if _, found := existingIPs[ipStr]; !found {
 			dest := &ipvs.Destination{
+				AddressFamily:   unix.AF_INET,
 				Address:         ip,
-				Port:            0,
-				Weight:          1,
+				Port:            slb.backendPort,
+				Weight:          slb.getBackendWeight(ip),
 				ConnectionFlags: ipvs.ConnectionFlagMasq,
 			}
 			err = handle.NewDestination(svc, dest)
@@ -200,6 +470,8 @@ func (slb *serviceLb) SetBackends(ips []net.IP) error {
 	// Remove destinations that are no longer desired
 	for ipStr, dest := range existingIPs {
 		if _, found := desiredIPs[ipStr]; !found {
+			// The health and weight of the backend are kept, until it's removed via RemoveBackend
+			slb.stopDraining(dest.Address)
 			err = handle.DelDestination(svc, dest)
 			if err != nil {
 				return errors.WithMessagef(err, "failed to delete backend ip %s from service load balancer for service %s and networks %s", ipStr, slb.serviceID, slb.dockerNetworkID)
@@ -207,7 +479,7 @@ func (slb *serviceLb) SetBackends(ips []net.IP) error {
 		}
 	}
 
-	slb.backendIPs = ips
+	slb.backendIPs = lo.Values(desiredIPs)
 
 	return nil
 }
@@ -221,7 +493,10 @@ func (slb *serviceLb) ensureIpvsService() (*ipvs.Service, error) {
 
 	svc := &ipvs.Service{
 		FWMark:        slb.fwmark,
-		SchedName:     "rr",
+		SchedName:     slb.ipvsConfig.SchedName,
+		Flags:         slb.ipvsConfig.Flags,
+		Timeout:       slb.ipvsConfig.Timeout,
+		Netmask:       slb.ipvsConfig.Netmask,
 		AddressFamily: unix.AF_INET,
 	}
 
@@ -238,7 +513,10 @@ func (slb *serviceLb) ensureIpvsService() (*ipvs.Service, error) {
 			return nil, fmt.Errorf("failed to get existing IPVS service: %v", err)
 		}
 
-		if existingSvc.SchedName != svc.SchedName || existingSvc.Flags != svc.Flags || existingSvc.Timeout != svc.Timeout || existingSvc.PEName != svc.PEName {
+		// The kernel reports the hashed flag, which can't be set
+		existingFlags := existingSvc.Flags &^ ipvsSvcFlagHashed
+		if existingSvc.SchedName != svc.SchedName || existingFlags != svc.Flags || existingSvc.Timeout != svc.Timeout ||
+			existingSvc.Netmask != svc.Netmask || existingSvc.PEName != svc.PEName {
 			err = handle.UpdateService(svc)
 			if err != nil {
 				return nil, fmt.Errorf("failed to update existing IPVS service: %v", err)
@@ -260,15 +538,38 @@ func (slb *serviceLb) ensureServiceLoadBalancerFrontend() error {
 		return err
 	}
 
-	fwmarkStr := strconv.FormatUint(uint64(slb.fwmark), 10)
+	previousIptablesRules := slb.iptablesRules
+	slb.iptablesRules = slb.getFrontendIptablesRules(vip)
+
+	err := networking.ApplyIpTablesRules(slb.iptablesRules, "create")
+	if err != nil {
+		return errors.WithMessagef(err, "failed to setup IP Tables rules for service load balancer for service %s in network %s", slb.serviceID, slb.dockerNetworkID)
+	}
 
-	var previousIptablesRules []networking.IptablesRule
+	// Rules that are still needed mustn't be deleted, because the new rules didn't create duplicates of them
+	obsoleteIptablesRules := lo.Filter(previousIptablesRules, func(previous networking.IptablesRule, index int) bool {
+		return !lo.ContainsBy(slb.iptablesRules, func(current networking.IptablesRule) bool {
+			return previous.Table == current.Table && previous.Chain == current.Chain && slices.Equal(previous.RuleSpec, current.RuleSpec)
+		})
+	})
 
-	if slb.iptablesRules != nil && len(slb.iptablesRules) > 0 {
-		copy(previousIptablesRules, slb.iptablesRules)
+	if len(obsoleteIptablesRules) > 0 {
+		err = networking.ApplyIpTablesRules(obsoleteIptablesRules, "delete")
+		if err != nil {
+			return errors.WithMessagef(err, "failed to setup IP Tables rules for service load balancer for service %s in network %s", slb.serviceID, slb.dockerNetworkID)
+		}
 	}
 
-	slb.iptablesRules = []networking.IptablesRule{
+	return nil
+}
+
+// getFrontendIptablesRules marks the traffic to the VIP for IPVS. If the load balancer is restricted to the ports
+// of the service, only the traffic to these ports is marked. The VIP is a local address, so the remaining traffic
+// would reach the services of the host. We reject it instead, except for pings, which are answered locally
+func (slb *serviceLb) getFrontendIptablesRules(vip string) []networking.IptablesRule {
+	fwmarkStr := strconv.FormatUint(uint64(slb.fwmark), 10)
+
+	rules := []networking.IptablesRule{
 		{
 			Table: "nat",
 			Chain: "POSTROUTING",
@@ -279,44 +580,115 @@ func (slb *serviceLb) ensureServiceLoadBalancerFrontend() error {
 				"-j", "MASQUERADE",
 			},
 		},
-		{
-			Table: "mangle",
-			Chain: "PREROUTING",
+	}
+
+	if len(slb.ports) == 0 {
+		// Like docker, we load balance all TCP and UDP traffic to the VIP
+		for _, protocol := range []string{"udp", "tcp"} {
+			rules = append(rules, networking.IptablesRule{
+				Table: "mangle",
+				Chain: "PREROUTING",
+				RuleSpec: []string{
+					"-d", vip,
+					"-p", protocol,
+					"-j", "MARK",
+					"--set-mark", fwmarkStr,
+				},
+			})
+		}
+	} else {
+		for _, port := range lo.UniqBy(slb.ports, func(item common.ServicePort) string {
+			return fmt.Sprintf("%s/%d", item.Protocol, item.TargetPort)
+		}) {
+			protocol := getProtocol(port)
+			rules = append(rules, networking.IptablesRule{
+				Table: "mangle",
+				Chain: "PREROUTING",
+				RuleSpec: []string{
+					"-d", vip,
+					"-p", protocol,
+					"-m", protocol,
+					"--dport", strconv.FormatUint(uint64(port.TargetPort), 10),
+					"-j", "MARK",
+					"--set-mark", fwmarkStr,
+				},
+			})
+		}
+	}
+
+	if len(slb.ports) == 0 {
+		return rules
+	}
+
+	return append(rules,
+		networking.IptablesRule{
+			Table: "filter",
+			Chain: "INPUT",
 			RuleSpec: []string{
 				"-d", vip,
-				"-p", "udp",
-				"-j", "MARK",
-				"--set-mark", fwmarkStr,
+				"-p", "icmp",
+				"--icmp-type", "echo-request",
+				// Pings are never marked. The mark identifies the rule for CleanUpStaleLoadBalancers
+				"-m", "mark",
+				"!", "--mark", fwmarkStr,
+				"-j", "ACCEPT",
 			},
 		},
-		{
-			Table: "mangle",
-			Chain: "PREROUTING",
+		networking.IptablesRule{
+			Table: "filter",
+			Chain: "INPUT",
 			RuleSpec: []string{
 				"-d", vip,
 				"-p", "tcp",
-				"-j", "MARK",
-				"--set-mark", fwmarkStr,
+				"-m", "mark",
+				"!", "--mark", fwmarkStr,
+				"-j", "REJECT",
+				"--reject-with", "tcp-reset",
 			},
 		},
-	}
+		networking.IptablesRule{
+			Table: "filter",
+			Chain: "INPUT",
+			RuleSpec: []string{
+				"-d", vip,
+				"!", "-p", "icmp",
+				"-m", "mark",
+				"!", "--mark", fwmarkStr,
+				"-j", "REJECT",
+				"--reject-with", "icmp-port-unreachable",
+			},
+		})
+}
 
-	err := networking.ApplyIpTablesRules(slb.iptablesRules, "create")
+func (slb *serviceLb) Delete() error {
+	slb.Lock()
+	defer slb.Unlock()
+
+	err := slb.deleteIpvsServiceAndIptablesRules()
 	if err != nil {
-		return errors.WithMessagef(err, "failed to setup IP Tables rules for service load balancer for service %s in network %s", slb.serviceID, slb.dockerNetworkID)
+		return err
 	}
 
-	if len(previousIptablesRules) > 0 {
-		err = networking.ApplyIpTablesRules(previousIptablesRules, "delete")
-		if err != nil {
-			return errors.WithMessagef(err, "failed to setup IP Tables rules for service load balancer for service %s in network %s", slb.serviceID, slb.dockerNetworkID)
-		}
+	err = networking.StopListeningOnAddress(slb.link, slb.frontendIP.String())
+	if err != nil {
+		return errors.WithMessagef(err, "failed to remove IP %s from interface %s", slb.frontendIP, slb.link.Attrs().Name)
 	}
 
 	return nil
 }
 
-func (slb *serviceLb) Delete() error {
+// deleteIpvsServiceAndIptablesRules expects the lock to be held
+func (slb *serviceLb) deleteIpvsServiceAndIptablesRules() error {
+	for _, timer := range slb.drainingBackends {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	slb.drainingBackends = make(map[string]*time.Timer)
+	slb.drainedBackends = make(map[string]struct{})
+	slb.unhealthyBackends = make(map[string]struct{})
+	slb.weights = make(map[string]int)
+
 	err := networking.ApplyIpTablesRules(slb.iptablesRules, "delete")
 	if err != nil {
 		return errors.WithMessagef(err, "failed to remove IP Tables rules for service load balancer for service %s in network %s", slb.serviceID, slb.dockerNetworkID)
@@ -330,7 +702,7 @@ func (slb *serviceLb) Delete() error {
 
 	svc := &ipvs.Service{
 		FWMark:        slb.fwmark,
-		SchedName:     "rr",
+		SchedName:     slb.ipvsConfig.SchedName,
 		AddressFamily: unix.AF_INET,
 	}
 
@@ -339,10 +711,5 @@ func (slb *serviceLb) Delete() error {
 		return errors.WithMessagef(err, "failed to delete IPVS service of service load balancer of service %s and network %s", slb.serviceID, slb.dockerNetworkID)
 	}
 
-	err = networking.StopListeningOnAddress(slb.link, slb.frontendIP.String())
-	if err != nil {
-		return errors.WithMessagef(err, "failed to remove IP %s from interface %s", slb.frontendIP, slb.link.Attrs().Name)
-	}
-
 	return nil
 }
@@ -2,7 +2,6 @@ package service_lb
 
 import (
 	"fmt"
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/moby/ipvs"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
@@ -14,8 +13,11 @@ import (
 	"log"
 	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Load balancer per service and flannel network
@@ -24,12 +26,20 @@ import (
 
 type ServiceLbsManagement interface {
 	SetFlannelNetwork(dockerNetworkID string, network flannel_network.Network) error
-	RegisterOtherNetwork(dockerNetworkID string)
+	RegisterOtherNetwork(network common.NetworkInfo)
 	DeleteNetwork(dockerNetworkID string) error
 	CreateLoadBalancer(service common.Service) <-chan error
 	DeleteLoadBalancer(serviceID string) error
 }
 
+type ServiceLbsConfig struct {
+	// DrainTimeout is the time after which a draining backend is removed, even if its container is still running.
+	// 0 keeps it until the container died
+	DrainTimeout time.Duration
+	// Ingress publishes the published ports of the services with publish mode ingress on all nodes
+	Ingress bool
+}
+
 type loadBalancerData struct {
 	FrontendIPs map[string]net.IP `json:"FrontendIPs"` // docker network ID -> VIP
 }
@@ -44,19 +54,23 @@ func (d loadBalancerData) Equals(other common.Equaler) bool {
 }
 
 type serviceLbManagement struct {
+	config                      ServiceLbsConfig
 	services                    *common.ConcurrentMap[string, common.Service]
 	servicesEventsUnsubscribers *common.ConcurrentMap[string, func()]
 	loadBalancers               *common.ConcurrentMap[string, *common.ConcurrentMap[string, NetworkSpecificServiceLb]]
 	loadBalancersData           etcd.WriteOnlyStore[loadBalancerData]
+	ingressLoadBalancers        *common.ConcurrentMap[string, *common.ConcurrentMap[string, IngressLb]] // service ID -> ingress key -> load balancer
+	healthCheckers              *common.ConcurrentMap[string, *healthChecker]
+	healthCheckResults          etcd.WriteOnlyStore[backendsHealth]
 	fwmarksManagement           FwmarksManagement
 	flannelNetworksByDockerID   *common.ConcurrentMap[string, flannel_network.Network]
-	otherNetworksByDockerID     *common.ConcurrentMap[string, struct{}]
+	otherNetworksByDockerID     *common.ConcurrentMap[string, common.NetworkInfo]
 	hostname                    string
 	networksChanged             *sync.Cond
 	sync.Mutex
 }
 
-func NewServiceLbManagement(etcdClient etcd.Client) (ServiceLbsManagement, error) {
+func NewServiceLbManagement(etcdClient etcd.Client, config ServiceLbsConfig) (ServiceLbsManagement, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, errors.WithMessage(err, "error getting hostname")
@@ -67,12 +81,19 @@ func NewServiceLbManagement(etcdClient etcd.Client) (ServiceLbsManagement, error
 		return nil, errors.WithMessage(err, "error initializing load balancer data store")
 	}
 
+	// The results of the health checks are only published, so we don't need the existing ones
+	healthCheckResults := etcd.NewWriteOnlyStore(etcdClient.CreateSubClient(hostname, "health"), etcd.ItemsHandlers[backendsHealth]{})
+
 	return &serviceLbManagement{
+		config:                      config,
 		loadBalancers:               common.NewConcurrentMap[string, *common.ConcurrentMap[string, NetworkSpecificServiceLb]](),
 		loadBalancersData:           loadBalancerData,
+		ingressLoadBalancers:        common.NewConcurrentMap[string, *common.ConcurrentMap[string, IngressLb]](),
+		healthCheckers:              common.NewConcurrentMap[string, *healthChecker](),
+		healthCheckResults:          healthCheckResults,
 		fwmarksManagement:           NewFwmarksManagement(etcdClient.CreateSubClient(hostname, "fwmarks")),
 		flannelNetworksByDockerID:   common.NewConcurrentMap[string, flannel_network.Network](),
-		otherNetworksByDockerID:     common.NewConcurrentMap[string, struct{}](),
+		otherNetworksByDockerID:     common.NewConcurrentMap[string, common.NetworkInfo](),
 		hostname:                    hostname,
 		services:                    common.NewConcurrentMap[string, common.Service](),
 		servicesEventsUnsubscribers: common.NewConcurrentMap[string, func()](),
@@ -89,9 +110,20 @@ func (m *serviceLbManagement) SetFlannelNetwork(dockerNetworkID string, network
 	return nil
 }
 
-func (m *serviceLbManagement) RegisterOtherNetwork(dockerNetworkID string) {
-	m.otherNetworksByDockerID.Set(dockerNetworkID, struct{}{})
+func (m *serviceLbManagement) RegisterOtherNetwork(network common.NetworkInfo) {
+	m.otherNetworksByDockerID.Set(network.DockerID, network)
 	m.networksChanged.Broadcast()
+
+	if network.Ingress && m.config.Ingress {
+		// The routing mesh of docker takes over the published ports of the services in its ingress network
+		m.Lock()
+		defer m.Unlock()
+		for _, service := range m.services.Values() {
+			if err := m.updateIngressLoadBalancersLocked(service.GetInfo()); err != nil {
+				log.Printf("Error updating ingress load balancers of service %s after the ingress network of docker was added: %v\n", service.GetInfo().ID, err)
+			}
+		}
+	}
 }
 
 func (m *serviceLbManagement) DeleteNetwork(dockerNetworkID string) error {
@@ -104,10 +136,20 @@ func (m *serviceLbManagement) DeleteNetwork(dockerNetworkID string) error {
 	return nil
 }
 
-func (m *serviceLbManagement) addBackendIPsToLoadBalancer(serviceID string, ips map[string]net.IP) error {
+func (m *serviceLbManagement) addBackendIPsToLoadBalancer(serviceID string, ips map[string]net.IP, weight int) error {
 	m.Lock()
 	defer m.Unlock()
 
+	err := m.forEachIngressLbLocked(serviceID, ips, func(lb IngressLb, ip net.IP) error {
+		if err := lb.SetBackendWeight(ip, weight); err != nil {
+			return err
+		}
+		return lb.AddBackend(ip)
+	})
+	if err != nil {
+		return err
+	}
+
 	lbs, exists := m.loadBalancers.Get(serviceID)
 	if !exists {
 		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
@@ -117,7 +159,11 @@ func (m *serviceLbManagement) addBackendIPsToLoadBalancer(serviceID string, ips
 		if !exists {
 			return fmt.Errorf("no load balancer for network %s for service %s found. This is a bug", dockerNetworkID, serviceID)
 		}
-		err := lb.AddBackend(ip)
+		err := lb.SetBackendWeight(ip, weight)
+		if err != nil {
+			return errors.WithMessagef(err, "error setting weight of backend ip %s of load balancer for service %s and network %s", ip, serviceID, dockerNetworkID)
+		}
+		err = lb.AddBackend(ip)
 		if err != nil {
 			return errors.WithMessagef(err, "error adding backend ip %s to load balancer for service %s and network %s", ip, serviceID, dockerNetworkID)
 		}
@@ -130,6 +176,13 @@ func (m *serviceLbManagement) removeBackendIPsFromLoadBalancer(serviceID string,
 	m.Lock()
 	defer m.Unlock()
 
+	err := m.forEachIngressLbLocked(serviceID, ips, func(lb IngressLb, ip net.IP) error {
+		return lb.RemoveBackend(ip)
+	})
+	if err != nil {
+		return err
+	}
+
 	lbs, exists := m.loadBalancers.Get(serviceID)
 	if !exists {
 		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
@@ -149,6 +202,459 @@ func (m *serviceLbManagement) removeBackendIPsFromLoadBalancer(serviceID string,
 	return nil
 }
 
+func (m *serviceLbManagement) drainBackendIPsOfLoadBalancer(serviceID string, ips map[string]net.IP) error {
+	m.Lock()
+	defer m.Unlock()
+
+	err := m.forEachIngressLbLocked(serviceID, ips, func(lb IngressLb, ip net.IP) error {
+		return lb.DrainBackend(ip)
+	})
+	if err != nil {
+		return err
+	}
+
+	lbs, exists := m.loadBalancers.Get(serviceID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
+	}
+
+	for dockerNetworkID, ip := range ips {
+		lb, exists := lbs.Get(dockerNetworkID)
+		if !exists {
+			return fmt.Errorf("no load balancer for network %s for service %s found. This is a bug", dockerNetworkID, serviceID)
+		}
+		err := lb.DrainBackend(ip)
+		if err != nil {
+			return errors.WithMessagef(err, "error draining backend ip %s of load balancer for service %s and network %s", ip, serviceID, dockerNetworkID)
+		}
+	}
+
+	return nil
+}
+
+func (m *serviceLbManagement) setBackendWeights(serviceID string, ips map[string]net.IP, weight int) error {
+	m.Lock()
+	defer m.Unlock()
+
+	err := m.forEachIngressLbLocked(serviceID, ips, func(lb IngressLb, ip net.IP) error {
+		return lb.SetBackendWeight(ip, weight)
+	})
+	if err != nil {
+		return err
+	}
+
+	lbs, exists := m.loadBalancers.Get(serviceID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
+	}
+
+	for dockerNetworkID, ip := range ips {
+		lb, exists := lbs.Get(dockerNetworkID)
+		if !exists {
+			continue
+		}
+		err := lb.SetBackendWeight(ip, weight)
+		if err != nil {
+			return errors.WithMessagef(err, "error setting weight of backend ip %s of load balancer for service %s and network %s", ip, serviceID, dockerNetworkID)
+		}
+	}
+
+	return nil
+}
+
+func (m *serviceLbManagement) applyTrafficPolicy(service common.Service) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.applyTrafficPolicyLocked(service.GetInfo())
+}
+
+// applyTrafficPolicyLocked sets the backends of all load balancers of the service to the containers that the traffic
+// policy of the service selects. Expects the lock to be held
+func (m *serviceLbManagement) applyTrafficPolicyLocked(serviceInfo common.ServiceInfo) error {
+	backendIPs := map[string][]net.IP{}  // docker network ID -> IPs
+	drainingIPs := map[string][]net.IP{} // docker network ID -> IPs
+	weights := map[string]int{}          // IP -> weight
+	for _, container := range getBackendContainers(serviceInfo, m.hostname) {
+		for dockerNetworkID, ip := range container.IPs {
+			backendIPs[dockerNetworkID] = append(backendIPs[dockerNetworkID], ip)
+			weights[ip.String()] = getContainerWeight(container, serviceInfo.Labels)
+			if container.Draining {
+				drainingIPs[dockerNetworkID] = append(drainingIPs[dockerNetworkID], ip)
+			}
+		}
+	}
+
+	setBackends := func(lb interface {
+		SetBackendWeight(ip net.IP, weight int) error
+		SetBackends(ips []net.IP) error
+		DrainBackend(ip net.IP) error
+	}, dockerNetworkID string) error {
+		for _, ip := range backendIPs[dockerNetworkID] {
+			if err := lb.SetBackendWeight(ip, weights[ip.String()]); err != nil {
+				return err
+			}
+		}
+		if err := lb.SetBackends(backendIPs[dockerNetworkID]); err != nil {
+			return err
+		}
+		// Draining backends that were just added get no new connections either
+		for _, ip := range drainingIPs[dockerNetworkID] {
+			if err := lb.DrainBackend(ip); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lbs, exists := m.loadBalancers.Get(serviceInfo.ID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceInfo.ID)
+	}
+	for _, dockerNetworkID := range lbs.Keys() {
+		lb, exists := lbs.Get(dockerNetworkID)
+		if !exists {
+			continue
+		}
+		if err := setBackends(lb, dockerNetworkID); err != nil {
+			return errors.WithMessagef(err, "error setting backends of load balancer for service %s and network %s", serviceInfo.ID, dockerNetworkID)
+		}
+	}
+
+	if ingressLbs, exists := m.ingressLoadBalancers.Get(serviceInfo.ID); exists {
+		for _, key := range ingressLbs.Keys() {
+			lb, exists := ingressLbs.Get(key)
+			if !exists {
+				continue
+			}
+			if err := setBackends(lb, lb.GetDockerNetworkID()); err != nil {
+				return errors.WithMessagef(err, "error setting backends of ingress load balancer %s for service %s", key, serviceInfo.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *serviceLbManagement) updateIpvsServiceConfig(serviceID string, config IpvsServiceConfig) error {
+	m.Lock()
+	defer m.Unlock()
+
+	lbs, exists := m.loadBalancers.Get(serviceID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
+	}
+
+	for _, dockerNetworkID := range lbs.Keys() {
+		lb, exists := lbs.Get(dockerNetworkID)
+		if !exists {
+			continue
+		}
+		err := lb.SetIpvsServiceConfig(config)
+		if err != nil {
+			return errors.WithMessagef(err, "error updating IPVS config of load balancer for service %s and network %s", serviceID, dockerNetworkID)
+		}
+	}
+
+	if ingressLbs, exists := m.ingressLoadBalancers.Get(serviceID); exists {
+		for _, key := range ingressLbs.Keys() {
+			lb, exists := ingressLbs.Get(key)
+			if !exists {
+				continue
+			}
+			err := lb.SetIpvsServiceConfig(config)
+			if err != nil {
+				return errors.WithMessagef(err, "error updating IPVS config of ingress load balancer %s for service %s", key, serviceID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *serviceLbManagement) updatePorts(service common.Service) error {
+	m.Lock()
+	defer m.Unlock()
+
+	serviceInfo := service.GetInfo()
+	serviceID := serviceInfo.ID
+	ports := getRestrictedPorts(serviceInfo)
+
+	lbs, exists := m.loadBalancers.Get(serviceID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found. This is a bug", serviceID)
+	}
+
+	for _, dockerNetworkID := range lbs.Keys() {
+		lb, exists := lbs.Get(dockerNetworkID)
+		if !exists {
+			continue
+		}
+		err := lb.SetPorts(ports)
+		if err != nil {
+			return errors.WithMessagef(err, "error updating ports of load balancer for service %s and network %s", serviceID, dockerNetworkID)
+		}
+	}
+
+	return m.updateIngressLoadBalancersLocked(serviceInfo)
+}
+
+// getRestrictedPorts returns the ports the load balancers of the service are restricted to. The label
+// flannel-np.lb-restrict-ports set to false lifts the restriction
+func getRestrictedPorts(serviceInfo common.ServiceInfo) []common.ServicePort {
+	if strings.ToLower(strings.TrimSpace(serviceInfo.Labels[common.LBRestrictPortsLabel])) == "false" {
+		return nil
+	}
+
+	return serviceInfo.Ports
+}
+
+// updateHealthChecker (re)starts the health checks of the backends of the service if their config changed
+func (m *serviceLbManagement) updateHealthChecker(service common.Service) {
+	serviceInfo := service.GetInfo()
+	config := NewHealthCheckConfig(serviceInfo.Labels, serviceInfo.Ports)
+
+	m.Lock()
+	defer m.Unlock()
+
+	existing, exists := m.healthCheckers.Get(serviceInfo.ID)
+	if exists && existing.config == config {
+		return
+	}
+	if !exists && config.Type == "" {
+		return
+	}
+
+	if exists {
+		existing.Stop()
+		m.healthCheckers.Remove(serviceInfo.ID)
+		// The new health checks start with healthy backends
+		for _, container := range serviceInfo.Containers {
+			for dockerNetworkID, ip := range m.getLoadBalancedIPs(container) {
+				if err := m.setBackendHealthLocked(serviceInfo.ID, dockerNetworkID, ip, true); err != nil {
+					log.Printf("error restoring health of backend %s of service %s: %v\n", ip, serviceInfo.ID, err)
+				}
+			}
+		}
+	}
+
+	if config.Type == "" {
+		fmt.Printf("Stopped health checks of service %s\n", serviceInfo.ID)
+		if err := m.healthCheckResults.DeleteItem(serviceInfo.ID); err != nil {
+			log.Printf("failed to delete health check results of service %s: %v\n", serviceInfo.ID, err)
+		}
+		return
+	}
+
+	fmt.Printf("Starting health checks of service %s: %+v\n", serviceInfo.ID, config)
+	checker := newHealthChecker(serviceInfo.ID, config,
+		func(dockerNetworkID string, ip net.IP, healthy bool) error {
+			m.Lock()
+			defer m.Unlock()
+			return m.setBackendHealthLocked(serviceInfo.ID, dockerNetworkID, ip, healthy)
+		},
+		func(health map[string]BackendHealth) error {
+			return m.healthCheckResults.AddOrUpdateItem(serviceInfo.ID, backendsHealth{Backends: health})
+		})
+	m.healthCheckers.Set(serviceInfo.ID, checker)
+	for _, container := range serviceInfo.Containers {
+		checker.AddContainer(container.ID, m.getLoadBalancedIPs(container))
+	}
+}
+
+// setBackendHealthLocked expects the lock to be held
+func (m *serviceLbManagement) setBackendHealthLocked(serviceID, dockerNetworkID string, ip net.IP, healthy bool) error {
+	err := m.forEachIngressLbLocked(serviceID, map[string]net.IP{dockerNetworkID: ip}, func(lb IngressLb, ip net.IP) error {
+		return lb.SetBackendHealth(ip, healthy)
+	})
+	if err != nil {
+		return err
+	}
+
+	lbs, exists := m.loadBalancers.Get(serviceID)
+	if !exists {
+		return fmt.Errorf("no load balancer for service %s found", serviceID)
+	}
+	lb, exists := lbs.Get(dockerNetworkID)
+	if !exists {
+		return fmt.Errorf("no load balancer for network %s for service %s found", dockerNetworkID, serviceID)
+	}
+
+	return lb.SetBackendHealth(ip, healthy)
+}
+
+// getContainerWeight returns the IPVS weight of the backends of the container. The weight from the labels of the
+// container or its node takes precedence over the one from the labels of the service
+func getContainerWeight(container common.ContainerInfo, serviceLabels map[string]string) int {
+	if container.Weight > 0 {
+		return container.Weight
+	}
+	if weight := common.ParseUint32Label(serviceLabels, common.LBWeightLabel); weight > 0 {
+		return int(weight)
+	}
+
+	return 1
+}
+
+// getLoadBalancedIPs returns the IPs of the container in the networks in which we load balance, i.e. our networks
+func (m *serviceLbManagement) getLoadBalancedIPs(container common.ContainerInfo) map[string]net.IP {
+	return lo.PickBy(container.IPs, func(dockerNetworkID string, ip net.IP) bool {
+		_, exists := m.flannelNetworksByDockerID.Get(dockerNetworkID)
+		return exists
+	})
+}
+
+// updateIngressLoadBalancersLocked creates and deletes the ingress load balancers of the service according to its
+// published ports. Expects the lock to be held
+func (m *serviceLbManagement) updateIngressLoadBalancersLocked(serviceInfo common.ServiceInfo) error {
+	if !m.config.Ingress {
+		return nil
+	}
+
+	ingressLbs, _, _ := m.ingressLoadBalancers.GetOrAdd(serviceInfo.ID, func() (*common.ConcurrentMap[string, IngressLb], error) {
+		return common.NewConcurrentMap[string, IngressLb](), nil
+	})
+
+	ports := lo.UniqBy(getIngressPorts(serviceInfo.Ports), getIngressKey)
+	dockerNetworkID, hasFlannelNetwork := m.getIngressNetworkID(serviceInfo)
+	if !hasFlannelNetwork {
+		if len(ports) > 0 {
+			log.Printf("Service %s has published ports, but isn't attached to a flannel network. Not publishing them\n", serviceInfo.ID)
+		}
+		ports = []common.ServicePort{}
+	}
+	if len(ports) > 0 && m.isInDockerIngressNetwork(serviceInfo) {
+		log.Printf("Service %s has published ports, but is attached to the ingress network of docker, whose routing mesh publishes them. Not publishing them\n", serviceInfo.ID)
+		ports = []common.ServicePort{}
+	}
+	desiredPorts := lo.KeyBy(ports, getIngressKey)
+
+	for _, key := range ingressLbs.Keys() {
+		lb, exists := ingressLbs.Get(key)
+		if !exists {
+			continue
+		}
+		port, isDesired := desiredPorts[key]
+		if isDesired && lb.GetDockerNetworkID() == dockerNetworkID && lb.GetPort().TargetPort == port.TargetPort {
+			continue
+		}
+		ingressLbs.Remove(key)
+		if err := m.deleteIngressLb(serviceInfo.ID, key, lb); err != nil {
+			return err
+		}
+	}
+
+	ipvsConfig := NewIpvsServiceConfig(serviceInfo.Labels)
+	for key, port := range desiredPorts {
+		lb, wasAdded, err := ingressLbs.GetOrAdd(key, func() (IngressLb, error) {
+			fwmark, err := m.fwmarksManagement.Get(serviceInfo.ID, key)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "failed to get fwmark for service %s and published port %d", serviceInfo.ID, port.PublishedPort)
+			}
+			fmt.Printf("Publishing port %d/%s of service %s via network %s\n", port.PublishedPort, getProtocol(port), serviceInfo.ID, dockerNetworkID)
+			return NewIngressLb(dockerNetworkID, serviceInfo.ID, fwmark, port, ipvsConfig, m.config.DrainTimeout)
+		})
+		if err != nil {
+			return err
+		}
+		if !wasAdded {
+			continue
+		}
+
+		checker, hasHealthChecker := m.healthCheckers.Get(serviceInfo.ID)
+		for _, container := range getBackendContainers(serviceInfo, m.hostname) {
+			ip, exists := container.IPs[dockerNetworkID]
+			if !exists {
+				continue
+			}
+			if err := lb.SetBackendWeight(ip, getContainerWeight(container, serviceInfo.Labels)); err != nil {
+				return errors.WithMessagef(err, "error setting weight of backend ip %s of ingress load balancer %s for service %s", ip, key, serviceInfo.ID)
+			}
+			if err := lb.AddBackend(ip); err != nil {
+				return errors.WithMessagef(err, "error adding backend ip %s to ingress load balancer %s for service %s", ip, key, serviceInfo.ID)
+			}
+			if hasHealthChecker && !checker.IsHealthy(ip) {
+				if err := lb.SetBackendHealth(ip, false); err != nil {
+					return errors.WithMessagef(err, "error setting health of backend ip %s of ingress load balancer %s for service %s", ip, key, serviceInfo.ID)
+				}
+			}
+			if container.Draining {
+				if err := lb.DrainBackend(ip); err != nil {
+					return errors.WithMessagef(err, "error draining backend ip %s of ingress load balancer %s for service %s", ip, key, serviceInfo.ID)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// getIngressNetworkID returns the flannel network via which the ingress load balancers reach the backends. All
+// nodes pick the same network
+func (m *serviceLbManagement) getIngressNetworkID(serviceInfo common.ServiceInfo) (string, bool) {
+	flannelNetworks := lo.Filter(serviceInfo.Networks, func(dockerNetworkID string, index int) bool {
+		_, exists := m.flannelNetworksByDockerID.Get(dockerNetworkID)
+		return exists
+	})
+	if len(flannelNetworks) == 0 {
+		return "", false
+	}
+
+	return slices.Min(flannelNetworks), true
+}
+
+// isInDockerIngressNetwork returns true if docker attached the service to its ingress network. The network isn't
+// part of the networks of the service spec, but the service has a VIP in it
+func (m *serviceLbManagement) isInDockerIngressNetwork(serviceInfo common.ServiceInfo) bool {
+	for dockerNetworkID := range serviceInfo.IpamVIPs {
+		if network, exists := m.otherNetworksByDockerID.Get(dockerNetworkID); exists && network.Ingress {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forEachIngressLbLocked calls fn for the ingress load balancers of the service that reach their backends via one
+// of the networks of ips. ips: docker network ID -> IP. Expects the lock to be held
+func (m *serviceLbManagement) forEachIngressLbLocked(serviceID string, ips map[string]net.IP, fn func(lb IngressLb, ip net.IP) error) error {
+	ingressLbs, exists := m.ingressLoadBalancers.Get(serviceID)
+	if !exists {
+		return nil
+	}
+
+	for _, key := range ingressLbs.Keys() {
+		lb, exists := ingressLbs.Get(key)
+		if !exists {
+			continue
+		}
+		ip, exists := ips[lb.GetDockerNetworkID()]
+		if !exists {
+			continue
+		}
+		if err := fn(lb, ip); err != nil {
+			return errors.WithMessagef(err, "error updating backend ip %s of ingress load balancer %s for service %s", ip, key, serviceID)
+		}
+	}
+
+	return nil
+}
+
+func (m *serviceLbManagement) deleteIngressLb(serviceID, key string, lb IngressLb) error {
+	fmt.Printf("Deleting ingress load balancer %s of service %s...\n", key, serviceID)
+	err := lb.Delete()
+	if err != nil {
+		return errors.WithMessagef(err, "failed to delete ingress load balancer %s for service %s", key, serviceID)
+	}
+
+	err = m.fwmarksManagement.Release(serviceID, key, lb.GetFwmark())
+	if err != nil {
+		return errors.WithMessagef(err, "failed to release fwmark of ingress load balancer %s for service %s", key, serviceID)
+	}
+
+	return nil
+}
+
 func (m *serviceLbManagement) CreateLoadBalancer(service common.Service) <-chan error {
 	done := make(chan error, 1)
 	errChan := m.createOrUpdateLoadBalancer(service)
@@ -170,27 +676,107 @@ func (m *serviceLbManagement) CreateLoadBalancer(service common.Service) <-chan
 
 		unsubscribeFromOnContainerAdded := service.Events().OnContainerAdded.Subscribe(func(data common.OnContainerData) {
 			serviceID := service.GetInfo().ID
-			err := m.addBackendIPsToLoadBalancer(serviceID, data.Container.IPs)
-			if err != nil {
-				log.Printf("error adding backend IPs to load balancer for service %s. Error: %v\n", serviceID, err)
+			if hasLocalTrafficPolicy(service.GetInfo().Labels) {
+				err := m.applyTrafficPolicy(service)
+				if err != nil {
+					log.Printf("error applying traffic policy of load balancer for service %s after container was added. Error: %v\n", serviceID, err)
+				}
+			} else {
+				weight := getContainerWeight(data.Container, service.GetInfo().Labels)
+				err := m.addBackendIPsToLoadBalancer(serviceID, data.Container.IPs, weight)
+				if err != nil {
+					log.Printf("error adding backend IPs to load balancer for service %s. Error: %v\n", serviceID, err)
+				}
+			}
+			if checker, exists := m.healthCheckers.Get(serviceID); exists {
+				checker.AddContainer(data.Container.ID, m.getLoadBalancedIPs(data.Container))
 			}
 		})
 
 		unsubscribeFromOnContainerRemoved := service.Events().OnContainerRemoved.Subscribe(func(data common.OnContainerData) {
 			fmt.Printf("Container removed from service %s: %+v\n", service.GetInfo().ID, data)
 			serviceID := service.GetInfo().ID
+			if checker, exists := m.healthCheckers.Get(serviceID); exists {
+				checker.RemoveContainer(data.Container.IPs)
+			}
 			err := m.removeBackendIPsFromLoadBalancer(serviceID, data.Container.IPs)
 			if err != nil {
 				log.Printf("error removing backend IPs from load balancer for service %s. Error: %v\n", serviceID, err)
 			}
+			// Without local tasks, the load balancers may fall back to the remote ones
+			if hasLocalTrafficPolicy(service.GetInfo().Labels) {
+				err := m.applyTrafficPolicy(service)
+				if err != nil {
+					log.Printf("error applying traffic policy of load balancer for service %s after container was removed. Error: %v\n", serviceID, err)
+				}
+			}
+		})
+
+		unsubscribeFromOnContainerDraining := service.Events().OnContainerDraining.Subscribe(func(data common.OnContainerData) {
+			serviceID := service.GetInfo().ID
+			err := m.drainBackendIPsOfLoadBalancer(serviceID, data.Container.IPs)
+			if err != nil {
+				log.Printf("error draining backend IPs of load balancer for service %s. Error: %v\n", serviceID, err)
+			}
+			if hasLocalTrafficPolicy(service.GetInfo().Labels) {
+				err := m.applyTrafficPolicy(service)
+				if err != nil {
+					log.Printf("error applying traffic policy of load balancer for service %s after container started draining. Error: %v\n", serviceID, err)
+				}
+			}
+		})
+
+		unsubscribeFromOnContainerWeightChanged := service.Events().OnContainerWeightChanged.Subscribe(func(data common.OnContainerData) {
+			serviceID := service.GetInfo().ID
+			weight := getContainerWeight(data.Container, service.GetInfo().Labels)
+			err := m.setBackendWeights(serviceID, data.Container.IPs, weight)
+			if err != nil {
+				log.Printf("error updating weights of backend IPs of load balancer for service %s. Error: %v\n", serviceID, err)
+			}
+		})
+
+		unsubscribeFromOnLabelsChanged := service.Events().OnLabelsChanged.Subscribe(func(s common.Service) {
+			serviceInfo := s.GetInfo()
+			serviceID := serviceInfo.ID
+			err := m.updateIpvsServiceConfig(serviceID, NewIpvsServiceConfig(serviceInfo.Labels))
+			if err != nil {
+				log.Printf("error updating IPVS config of load balancer for service %s after label changes. Error: %v\n", serviceID, err)
+			}
+			// The weight and the traffic policy of the service may have changed
+			err = m.applyTrafficPolicy(s)
+			if err != nil {
+				log.Printf("error updating backends of load balancer for service %s after label changes. Error: %v\n", serviceID, err)
+			}
+			// The restriction of the ports may have changed
+			err = m.updatePorts(s)
+			if err != nil {
+				log.Printf("error updating ports of load balancer for service %s after label changes. Error: %v\n", serviceID, err)
+			}
+			m.updateHealthChecker(s)
+		})
+
+		unsubscribeFromOnPortsChanged := service.Events().OnPortsChanged.Subscribe(func(s common.Service) {
+			serviceID := s.GetInfo().ID
+			err := m.updatePorts(s)
+			if err != nil {
+				log.Printf("error updating ports of load balancer for service %s after port changes. Error: %v\n", serviceID, err)
+			}
+			// The default port of the health checks may have changed
+			m.updateHealthChecker(s)
 		})
 
 		m.servicesEventsUnsubscribers.Set(service.GetInfo().ID, func() {
+			unsubscribeFromOnPortsChanged()
 			unsubscribeFromOnNetworksChanged()
+			unsubscribeFromOnLabelsChanged()
 			unsubscribeFromOnContainerAdded()
 			unsubscribeFromOnContainerRemoved()
+			unsubscribeFromOnContainerDraining()
+			unsubscribeFromOnContainerWeightChanged()
 		})
 
+		m.updateHealthChecker(service)
+
 		service.Events().OnEndpointModeChanged.Subscribe(func(s common.Service) {
 			info := s.GetInfo()
 			serviceID := info.ID
@@ -233,12 +819,31 @@ func (m *serviceLbManagement) DeleteLoadBalancer(serviceID string) error {
 		unsubscriber()
 	}
 
+	if checker, exists := m.healthCheckers.TryRemove(serviceID); exists {
+		checker.Stop()
+		if err := m.healthCheckResults.DeleteItem(serviceID); err != nil {
+			log.Printf("failed to delete health check results of service %s: %v\n", serviceID, err)
+		}
+	}
+
 	service, exists := m.services.TryRemove(serviceID)
 	if !exists {
 		return fmt.Errorf("no service found for ID %s.\n", serviceID)
 	}
 	serviceInfo := service.GetInfo()
 
+	if ingressLbs, exists := m.ingressLoadBalancers.TryRemove(serviceID); exists {
+		for _, key := range ingressLbs.Keys() {
+			lb, exists := ingressLbs.Get(key)
+			if !exists {
+				continue
+			}
+			if err := m.deleteIngressLb(serviceID, key, lb); err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, dockerNetworkID := range lbs.Keys() {
 		lb, exists := lbs.Get(dockerNetworkID)
 		if !exists {
@@ -302,6 +907,9 @@ func (m *serviceLbManagement) createOrUpdateLoadBalancer(service common.Service)
 		})
 
 		deleted, _ := lo.Difference(lbs.Keys(), serviceInfo.Networks)
+		// The labels and ports may have changed while we waited for the networks
+		ipvsConfig := NewIpvsServiceConfig(service.GetInfo().Labels)
+		ports := getRestrictedPorts(service.GetInfo())
 
 		for _, dockerNetworkID := range serviceInfo.Networks {
 			network, exists := m.flannelNetworksByDockerID.Get(dockerNetworkID)
@@ -321,18 +929,31 @@ func (m *serviceLbManagement) createOrUpdateLoadBalancer(service common.Service)
 				return
 			}
 
-			_, err = lbs.TryAdd(dockerNetworkID, func() (NetworkSpecificServiceLb, error) {
+			lb, wasAdded, err := lbs.GetOrAdd(dockerNetworkID, func() (NetworkSpecificServiceLb, error) {
 				fwmark, err := m.fwmarksManagement.Get(serviceInfo.ID, dockerNetworkID)
 				if err != nil {
 					return nil, errors.WithMessagef(err, "failed to get fwmark for service %s and network: %s", serviceInfo.ID, dockerNetworkID)
 				}
-				return NewNetworkSpecificServiceLb(link, dockerNetworkID, serviceInfo.ID, fwmark), nil
+				return NewNetworkSpecificServiceLb(link, dockerNetworkID, serviceInfo.ID, fwmark, ipvsConfig, ports, m.config.DrainTimeout), nil
 			})
 
 			if err != nil {
 				done <- err
 				return
 			}
+
+			if !wasAdded {
+				err = lb.SetIpvsServiceConfig(ipvsConfig)
+				if err != nil {
+					done <- err
+					return
+				}
+				err = lb.SetPorts(ports)
+				if err != nil {
+					done <- err
+					return
+				}
+			}
 		}
 
 		for _, deletedNetworkID := range deleted {
@@ -404,6 +1025,21 @@ func (m *serviceLbManagement) createOrUpdateLoadBalancer(service common.Service)
 		fmt.Printf("Service %s (%s) got these local VIPs: %v\n", serviceInfo.Name, serviceInfo.ID, data.FrontendIPs)
 		service.SetVIPs(data.FrontendIPs)
 
+		// The network of the ingress load balancers may have changed
+		err = m.updateIngressLoadBalancersLocked(service.GetInfo())
+		if err != nil {
+			done <- errors.WithMessagef(err, "error updating ingress load balancers for service %s", serviceInfo.ID)
+			return
+		}
+
+		if hasLocalTrafficPolicy(serviceInfo.Labels) {
+			err = m.applyTrafficPolicyLocked(service.GetInfo())
+			if err != nil {
+				done <- errors.WithMessagef(err, "error applying traffic policy of load balancers for service %s", serviceInfo.ID)
+				return
+			}
+		}
+
 		done <- nil
 	}()
 
@@ -434,11 +1070,6 @@ func CleanUpStaleLoadBalancers(etcdClient etcd.Client, existingServices []string
 		return errors.WithMessage(err, "error cleaning up stale fwmarks")
 	}
 
-	iptables, err := iptables.New()
-	if err != nil {
-		return errors.WithMessage(err, "Error creating iptables handle")
-	}
-
 	ipvsHandle, err := ipvs.New("")
 	if err != nil {
 		return errors.WithMessage(err, "Error creating IPVS handle")
@@ -449,17 +1080,15 @@ func CleanUpStaleLoadBalancers(etcdClient etcd.Client, existingServices []string
 	if err != nil {
 		return errors.WithMessage(err, "Error getting IPVS services")
 	}
-	iptablesRulesMangle, err := iptables.List("mangle", "PREROUTING")
-	if err != nil {
-		return errors.WithMessage(err, "Error getting iptables rules for table mangle")
-	}
-	iptablesRulesNat, err := iptables.List("nat", "POSTROUTING")
-	if err != nil {
-		return errors.WithMessage(err, "Error getting iptables rules for table nat")
+	iptablesRules := []networking.IptablesRule{}
+	for _, tableAndChain := range [][]string{{"mangle", "PREROUTING"}, {"mangle", "OUTPUT"}, {"nat", "POSTROUTING"}, {"filter", "INPUT"}} {
+		rules, err := networking.ListRules(tableAndChain[0], tableAndChain[1])
+		if err != nil {
+			return errors.WithMessagef(err, "Error getting iptables rules for table %s", tableAndChain[0])
+		}
+		iptablesRules = append(iptablesRules, rules...)
 	}
 
-	iptablesRules := append(iptablesRulesMangle, iptablesRulesNat...)
-
 	for _, staleFwmark := range staleFwmarks {
 		ipvsServicesForFwmark := lo.Filter(ipvsServices, func(item *ipvs.Service, index int) bool {
 			return item.FWMark == staleFwmark
@@ -471,35 +1100,43 @@ func CleanUpStaleLoadBalancers(etcdClient etcd.Client, existingServices []string
 			}
 		}
 
-		hexFwmark := fmt.Sprintf("0x%08x", staleFwmark)
-		iptablesRulesForFwmark := lo.Filter(iptablesRules, func(item string, index int) bool {
-			return strings.Contains(item, hexFwmark)
+		// iptables lists marks like --mark 0x5 or --set-xmark 0x5/0xffffffff, nftables lists them as we created them
+		hexFwmark := fmt.Sprintf("0x%x", staleFwmark)
+		decimalFwmark := strconv.FormatUint(uint64(staleFwmark), 10)
+		iptablesRulesForFwmark := lo.Filter(iptablesRules, func(item networking.IptablesRule, index int) bool {
+			for i, field := range item.RuleSpec {
+				if field == hexFwmark || strings.HasPrefix(field, hexFwmark+"/") {
+					return true
+				}
+				if field == decimalFwmark && i > 0 && (item.RuleSpec[i-1] == "--mark" || item.RuleSpec[i-1] == "--set-mark") {
+					return true
+				}
+			}
+			return false
 		})
 
-		for _, rawRule := range iptablesRulesForFwmark {
-			rule := strings.Fields(rawRule)[2:]
-			if len(rule) == 0 {
-				continue
-			}
-			if err := iptables.Delete("mangle", "PREROUTING", rule...); err != nil {
-				log.Printf("Error deleting iptables rule: %s, err: %v\n", rule, err)
+		for _, rule := range iptablesRulesForFwmark {
+			if err := networking.ApplyIpTablesRules([]networking.IptablesRule{rule}, "delete"); err != nil {
+				log.Printf("Error deleting iptables rule: %s, err: %v\n", rule.RuleSpec, err)
 			}
 		}
 	}
 
 	_, err = etcd.WithConnection(etcdClient, func(connection *etcd.Connection) (struct{}, error) {
-		prefix := etcdClient.GetKey(hostname, "data")
-		resp, err := connection.Client.Get(connection.Ctx, prefix, clientv3.WithPrefix())
-		if err != nil {
-			return struct{}{}, errors.WithMessagef(err, "error retrieving existing networks data from etcd")
-		}
+		for _, subKey := range []string{"data", "health"} {
+			prefix := etcdClient.GetKey(hostname, subKey)
+			resp, err := connection.Client.Get(connection.Ctx, prefix, clientv3.WithPrefix())
+			if err != nil {
+				return struct{}{}, errors.WithMessagef(err, "error retrieving existing load balancer %s from etcd", subKey)
+			}
 
-		for _, kv := range resp.Kvs {
-			key := strings.TrimLeft(strings.TrimPrefix(string(kv.Key), prefix), "/")
-			if !lo.Some(existingServices, []string{key}) {
-				_, err = connection.Client.Delete(connection.Ctx, string(kv.Key))
-				if err != nil {
-					log.Printf("Error deleting key %s, err: %v\n", string(kv.Key), err)
+			for _, kv := range resp.Kvs {
+				key := strings.TrimLeft(strings.TrimPrefix(string(kv.Key), prefix), "/")
+				if !lo.Some(existingServices, []string{key}) {
+					_, err = connection.Client.Delete(connection.Ctx, string(kv.Key))
+					if err != nil {
+						log.Printf("Error deleting key %s, err: %v\n", string(kv.Key), err)
+					}
 				}
 			}
 		}
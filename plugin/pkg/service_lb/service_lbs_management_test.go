@@ -0,0 +1,32 @@
+package service_lb
+
+import (
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"testing"
+)
+
+func TestGetContainerWeight(t *testing.T) {
+	tests := []struct {
+		name            string
+		containerWeight int
+		serviceLabels   map[string]string
+		expected        int
+	}{
+		{"default", 0, nil, 1},
+		{"weight of the container", 4, nil, 4},
+		{"weight of the service", 0, map[string]string{common.LBWeightLabel: "3"}, 3},
+		{"weight of the container takes precedence", 4, map[string]string{common.LBWeightLabel: "3"}, 4},
+		{"invalid weight of the service", 0, map[string]string{common.LBWeightLabel: "3.5"}, 1},
+		{"zero weight of the service", 0, map[string]string{common.LBWeightLabel: "0"}, 1},
+		{"weight of the service above the maximum", 0, map[string]string{common.LBWeightLabel: "4294967296"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			container := common.ContainerInfo{ID: "c1", Weight: test.containerWeight}
+			if actual := getContainerWeight(container, test.serviceLabels); actual != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
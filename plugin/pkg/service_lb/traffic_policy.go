@@ -0,0 +1,34 @@
+package service_lb
+
+import (
+	"github.com/samber/lo"
+	"github.com/sovarto/FlannelNetworkPlugin/pkg/common"
+	"strings"
+)
+
+// hasLocalTrafficPolicy returns true if the label flannel-np.lb-traffic-policy of the service restricts its load
+// balancers to the local tasks
+func hasLocalTrafficPolicy(labels map[string]string) bool {
+	policy := strings.ToLower(labels[common.LBTrafficPolicyLabel])
+	return policy == common.LBTrafficPolicyLocal || policy == common.LBTrafficPolicyPreferLocal
+}
+
+// getBackendContainers applies the label flannel-np.lb-traffic-policy of the service and returns the containers
+// that the load balancers of the service on the node with hostname forward to. With "local", only the containers
+// on this node are returned. With "prefer-local", all containers are returned if none of the containers on this
+// node is running without draining
+func getBackendContainers(serviceInfo common.ServiceInfo, hostname string) []common.ContainerInfo {
+	containers := lo.Values(serviceInfo.Containers)
+	local := lo.Filter(containers, func(container common.ContainerInfo, _ int) bool { return container.Node == hostname })
+
+	switch strings.ToLower(serviceInfo.Labels[common.LBTrafficPolicyLabel]) {
+	case common.LBTrafficPolicyLocal:
+		return local
+	case common.LBTrafficPolicyPreferLocal:
+		if lo.SomeBy(local, func(container common.ContainerInfo) bool { return !container.Draining }) {
+			return local
+		}
+	}
+
+	return containers
+}